@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"gocog/processor"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the name of the project config file, found by searching up from the working directory
+const configName = ".gocog.json"
+
+// the target run when no files are given on the command line
+const defaultTarget = "default"
+
+// config is a project config file. Options apply to every file, and sit between gocog's defaults
+// and the command line. Overrides apply to the files matching their globs, but options set on
+// the command line still win. Targets name lists of files, filelists and directories, and
+// Languages add to the languages that blocks can name after their start mark.
+// Paths and globs in the config are relative to the directory it's in.
+type config struct {
+	Options   flagSet                       `json:"options"`
+	Overrides []override                    `json:"overrides"`
+	Targets   map[string][]string           `json:"targets"`
+	Languages map[string]processor.Language `json:"languages"`
+
+	// name is the path to the config file
+	name string
+}
+
+// override holds the options for the files that match any of its globs.
+type override struct {
+	Files   []string `json:"files"`
+	Options flagSet  `json:"options"`
+}
+
+// flagSet holds options by their long flag name, with the values they take on the command line.
+// A true boolean sets the flag, a list gives the flag once for each item, and an object gives
+// the flag once for each key as KEY=VALUE, for --define.
+type flagSet map[string]interface{}
+
+// findConfig searches dir and each of its parents for the project config file,
+// and returns an empty string if there isn't one.
+func findConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		name := filepath.Join(dir, configName)
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// loadConfig reads the named config file, or searches for one from the working directory
+// if name is empty. If no config file is found, nil is returned.
+func loadConfig(name string) (*config, error) {
+	if name == "" {
+		found, err := findConfig(".")
+		if err != nil || found == "" {
+			return nil, err
+		}
+		name = found
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{name: name}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return nil, fmt.Errorf("Error parsing config file '%s': %s", name, err)
+	}
+	return c, nil
+}
+
+// apply sets the options from the config on opts.
+func (c *config) apply(opts *processor.Options) error {
+	if c == nil {
+		return nil
+	}
+	if err := c.Options.apply(opts); err != nil {
+		return fmt.Errorf("Error in options of config file '%s': %s", c.name, err)
+	}
+	return nil
+}
+
+// registerLanguages adds the languages from the config to the ones blocks can name.
+func (c *config) registerLanguages() {
+	if c == nil {
+		return
+	}
+	for name, lang := range c.Languages {
+		processor.RegisterLanguage(name, lang)
+	}
+}
+
+// target returns the files, filelists and directories of the named target, relative to the working directory.
+func (c *config) target(name string) ([]string, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Target '%s' given without a %s config file", name, configName)
+	}
+	names, ok := c.Targets[name]
+	if !ok {
+		return nil, fmt.Errorf("No target named '%s' in config file '%s'", name, c.name)
+	}
+	out := make([]string, len(names))
+	for i, s := range names {
+		if strings.HasPrefix(s, "@") {
+			out[i] = "@" + c.path(s[1:])
+		} else {
+			out[i] = c.path(s)
+		}
+	}
+	return out, nil
+}
+
+// path returns the path relative to the config file as a path relative to the working directory if possible.
+func (c *config) path(name string) string {
+	recursive := isRecursive(name)
+	name = strings.TrimSuffix(filepath.ToSlash(name), "...")
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(c.name), filepath.FromSlash(name))
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, name); err == nil {
+				name = rel
+			}
+		}
+	}
+	if recursive {
+		name = filepath.Join(name, "...")
+	}
+	return name
+}
+
+// optionsFor returns the options for the file, with the config's overrides for it applied to opts.
+// Options whose long names are in set were given on the command line and aren't overridden.
+func (c *config) optionsFor(file string, opts *processor.Options, set map[string]bool) (*processor.Options, error) {
+	if c == nil || len(c.Overrides) == 0 {
+		return opts, nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(filepath.Dir(c.name), abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		// the file isn't in the project
+		return opts, nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	o := *opts
+	overridden := false
+	for _, ov := range c.Overrides {
+		if !ov.matches(rel) {
+			continue
+		}
+		f := flagSet{}
+		for name, value := range ov.Options {
+			if !set[name] {
+				f[name] = value
+			}
+		}
+		if err := f.apply(&o); err != nil {
+			return nil, fmt.Errorf("Error in override for %q in config file '%s': %s", ov.Files, c.name, err)
+		}
+		overridden = true
+	}
+	if !overridden {
+		return opts, nil
+	}
+	o.Ext = processor.NormalizeExt(o.Ext)
+	return &o, nil
+}
+
+// matches reports whether the slash separated path, relative to the config file, matches any of the override's globs.
+func (ov override) matches(rel string) bool {
+	for _, glob := range ov.Files {
+		if processor.MatchGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// args returns the flags as command line arguments, sorted by name.
+func (f flagSet) args() ([]string, error) {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		switch v := f[name].(type) {
+		case bool:
+			if v {
+				args = append(args, "--"+name)
+			}
+		case string:
+			args = append(args, fmt.Sprintf("--%s=%s", name, v))
+		case float64:
+			args = append(args, fmt.Sprintf("--%s=%s", name, strconv.FormatFloat(v, 'f', -1, 64)))
+		case []interface{}:
+			for _, item := range v {
+				args = append(args, fmt.Sprintf("--%s=%v", name, item))
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				args = append(args, fmt.Sprintf("--%s=%s=%v", name, key, v[key]))
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported value for option '%s': %v", name, v)
+		}
+	}
+	return args, nil
+}
+
+// apply parses the flags onto opts, in the same way as a command line.
+func (f flagSet) apply(opts *processor.Options) error {
+	args, err := f.args()
+	if err != nil {
+		return err
+	}
+	inherited := opts.Define
+	remaining, err := flags.NewParser(opts, flags.None).ParseArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return errors.New("Only options can be given in a config file")
+	}
+	opts.Define = mergeDefines(inherited, opts.Define)
+	return nil
+}
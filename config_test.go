@@ -0,0 +1,247 @@
+package main
+
+import (
+	"gocog/processor"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates the files under root, with their slash separated names relative to root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// chdir changes the working directory to dir until the test is done.
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// testDefaults returns gocog's default options, as main sets them up.
+func testDefaults() processor.Options {
+	return processor.Options{
+		Command:   "go",
+		Args:      []string{"run", "%s"},
+		Ext:       ".go",
+		StartMark: "[[[",
+		EndMark:   "]]]",
+		Quiet:     true,
+	}
+}
+
+type LoadConfigData struct {
+	// dir is the working directory, relative to the root
+	dir string
+	// name is the config file given with --config
+	name string
+	// found is the config file expected to be loaded, relative to the root
+	found string
+	fails bool
+}
+
+func TestLoadConfig(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gocog.json":       `{"options": {"cmd": "sh"}}`,
+		"sub/deeper/a.go":   "",
+		"other/.gocog.json": `{"targets": {"default": ["a.go"]}}`,
+		"bad.json":          `{"option": {"cmd": "sh"}}`,
+		"broken.json":       `{"options": `,
+	})
+
+	tests := []LoadConfigData{
+		{"", "", ".gocog.json", false},
+		{"sub/deeper", "", ".gocog.json", false},
+		{"other", "", "other/.gocog.json", false},
+		{"sub", "../other/.gocog.json", "other/.gocog.json", false},
+		{"", "bad.json", "", true},
+		{"", "broken.json", "", true},
+		{"", "missing.json", "", true},
+	}
+
+	for i, test := range tests {
+		chdir(t, filepath.Join(root, filepath.FromSlash(test.dir)))
+		c, err := loadConfig(test.name)
+		if test.fails != (err != nil) {
+			t.Errorf("LoadConfig Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+		if test.fails {
+			continue
+		}
+		if c == nil {
+			t.Errorf("LoadConfig Test %d: Expected config '%s', Got none", i, test.found)
+			continue
+		}
+		abs, _ := filepath.Abs(c.name)
+		if expected := filepath.Join(root, filepath.FromSlash(test.found)); abs != expected {
+			t.Errorf("LoadConfig Test %d: Expected config '%s', Got '%s'", i, expected, abs)
+		}
+	}
+
+	// without a config file in the working directory or any of its parents, there's no config
+	chdir(t, t.TempDir())
+	if c, err := loadConfig(""); c != nil || err != nil {
+		t.Errorf("LoadConfig: Expected no config, Got %v, %v", c, err)
+	}
+}
+
+type OptionsForData struct {
+	// args is the command line, relative to the root
+	args      []string
+	command   string
+	ext       string
+	startMark string
+	suffix    string
+}
+
+func TestOptionsFor(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "project")
+	writeFiles(t, root, map[string]string{
+		".gocog.json": `{
+			"options": {"cmd": "sh", "ext": "sh", "suffix": " // gen"},
+			"overrides": [
+				{"files": ["*.md"], "options": {"startmark": "{{{", "endmark": "}}}", "ext": "txt"}},
+				{"files": ["docs/**"], "options": {"suffix": " <!-- gen -->"}}
+			]
+		}`,
+	})
+	chdir(t, root)
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := testDefaults()
+	if err := cfg.apply(&defaults); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []OptionsForData{
+		// the config's options replace the defaults
+		{[]string{"a.go"}, "sh", ".sh", "[[[", " // gen"},
+		// the command line replaces the config's options
+		{[]string{"--cmd", "bash", "a.go"}, "bash", ".sh", "[[[", " // gen"},
+		// overrides replace the config's options, for the files they match
+		{[]string{"b.md"}, "sh", ".txt", "{{{", " // gen"},
+		{[]string{"sub/b.md"}, "sh", ".txt", "{{{", " // gen"},
+		// the command line replaces overrides
+		{[]string{"-M", "<<<", "b.md"}, "sh", ".txt", "<<<", " // gen"},
+		{[]string{"--ext", "py", "b.md"}, "sh", ".py", "{{{", " // gen"},
+		// every matching override applies, with later ones winning
+		{[]string{"docs/c.md"}, "sh", ".txt", "{{{", " <!-- gen -->"},
+		// files outside the project get no overrides
+		{[]string{"../outside.md"}, "sh", ".sh", "[[[", " // gen"},
+	}
+
+	for i, test := range tests {
+		procs, err := handleCommandLine(test.args, defaults, scope{cfg: cfg})
+		if err != nil || len(procs) != 1 {
+			t.Errorf("OptionsFor Test %d: Expected one processor, Got %d, %v", i, len(procs), err)
+			continue
+		}
+		o := procs[0].Options
+		if o.Command != test.command || o.Ext != test.ext || o.StartMark != test.startMark || o.Suffix != test.suffix {
+			t.Errorf("OptionsFor Test %d: Expected cmd '%s', ext '%s', startmark '%s', suffix '%s', Got '%s', '%s', '%s', '%s'",
+				i, test.command, test.ext, test.startMark, test.suffix, o.Command, o.Ext, o.StartMark, o.Suffix)
+		}
+	}
+}
+
+type TargetData struct {
+	// dir is the working directory, relative to the root
+	dir    string
+	target string
+	names  []string
+	fails  bool
+}
+
+func TestConfigTarget(t *testing.T) {
+	root := t.TempDir()
+	abs := filepath.Join(root, "abs.go")
+	writeFiles(t, root, map[string]string{
+		".gocog.json": `{"targets": {
+			"default": ["a.go", "@lists/files.txt", "src/...", "..."],
+			"abs": ["` + filepath.ToSlash(abs) + `"]
+		}}`,
+		"sub/x.go": "",
+	})
+
+	tests := []TargetData{
+		{"", "default", []string{"a.go", "@lists/files.txt", "src/...", "..."}, false},
+		{"sub", "default", []string{"../a.go", "@../lists/files.txt", "../src/...", "../..."}, false},
+		{"sub", "abs", []string{abs}, false},
+		{"", "missing", nil, true},
+	}
+
+	for i, test := range tests {
+		chdir(t, filepath.Join(root, filepath.FromSlash(test.dir)))
+		cfg, err := loadConfig("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := cfg.target(test.target)
+		if test.fails != (err != nil) {
+			t.Errorf("ConfigTarget Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+		var expected []string
+		for _, name := range test.names {
+			expected = append(expected, filepath.FromSlash(name))
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("ConfigTarget Test %d: Expected names %q, Got %q", i, expected, names)
+		}
+	}
+
+	var none *config
+	if _, err := none.target("default"); err == nil {
+		t.Errorf("ConfigTarget: Expected an error for a target without a config file")
+	}
+}
+
+func TestConfigDir(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gocog.json": `{"overrides": [{"files": ["*.md"], "options": {"startmark": "{{{", "endmark": "}}}"}}]}`,
+		"a.go":        "// [[[gocog\n// gocog]]]\n// [[[end]]]\n",
+		"docs/b.md":   "<!-- {{{gocog\ngocog}}} -->\n<!-- {{{end}}} -->\n",
+		"docs/c.md":   "<!-- [[[gocog\ngocog]]] -->\n<!-- [[[end]]] -->\n",
+		"d.txt":       "no gocog code here\n",
+	})
+	chdir(t, root)
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// markdown files are found by the start mark from their override, not the default one
+	procs, err := handleCommandLine([]string{"./..."}, testDefaults(), scope{cfg: cfg})
+	if err != nil {
+		t.Fatalf("ConfigDir: Unexpected error: %v", err)
+	}
+	var files, marks []string
+	for _, p := range procs {
+		files = append(files, p.File)
+		marks = append(marks, p.StartMark)
+	}
+	if expected := []string{"a.go", filepath.Join("docs", "b.md")}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("ConfigDir: Expected files %q, Got %q", expected, files)
+	}
+	if expected := []string{"[[[", "{{{"}; !reflect.DeepEqual(marks, expected) {
+		t.Errorf("ConfigDir: Expected start marks %q, Got %q", expected, marks)
+	}
+}
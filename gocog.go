@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jessevdk/go-flags"
@@ -8,6 +10,8 @@ import (
 	"gocog/processor"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -17,31 +21,51 @@ const (
 	version = "gocog v1.0 build %s\n"
 )
 
+// exit statuses
+const (
+	// everything was processed successfully
+	exitOK = 0
+	// a file couldn't be processed, e.g. a generator failed or a file was out of date in check mode
+	exitFailed = 1
+	// the command line or a filelist couldn't be parsed
+	exitUsage = 2
+	// a file has a malformed gocog block, e.g. one without an end mark
+	exitSyntax = 3
+)
+
 func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
 func main() {
-	opts := processor.Options{
+	defaults := processor.Options{
 		Command:   "go",
 		Args:      []string{"run", "%s"},
 		Ext:       ".go",
 		StartMark: "[[[",
 		EndMark:   "]]]",
+		Jobs:      runtime.NumCPU(),
+		Cache:     true,
 	}
+	opts := defaults
 
 	p := flags.NewParser(&opts, flags.Default)
-	p.Usage = `[OPTIONS] [INFILE | @FILELIST] ...
+	p.Usage = `[OPTIONS] [INFILE | @FILELIST | DIR/...] ...
 
-  Runs gocog over each infile. 
+  Runs gocog over each infile. An infile of - is read from stdin and written to stdout.
   Strings prepended with @ are assumed to be files continaing newline delimited lists of gocog command lines.
   Command line options are passed to each command line in the file list, but options on the file list line
-  will override command line options. You may have filelists specified inside filelist files.`
+  will override command line options. You may have filelists specified inside filelist files.
+  DIR/... processes every file under DIR with gocog code in it, skipping files ignored by .gitignore or .gocogignore.
+  Exits with 1 if any file failed, 2 if the command line or a filelist is bad, and 3 if any file has a malformed gocog block.`
 
 	remaining, err := p.ParseArgs(os.Args[1:])
 	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(exitOK)
+		}
 		log.Println("Error parsing args:", err)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	ver := ""
@@ -60,100 +84,496 @@ func main() {
 	// [[[end]]]
 	if opts.Version {
 		fmt.Printf(version, ver)
-		os.Exit(0)
+		os.Exit(exitOK)
 	}
 
-	if len(remaining) < 1 {
-		p.WriteHelp(os.Stdout)
-		os.Exit(1)
+	cfg, err := loadConfig(opts.Config)
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitUsage)
+	}
+	cfg.registerLanguages()
+	if cfg != nil {
+		// the config's options sit between the defaults and the command line
+		if err := cfg.apply(&defaults); err != nil {
+			log.Println(err)
+			os.Exit(exitUsage)
+		}
+		opts = defaults
+		if remaining, err = p.ParseArgs(os.Args[1:]); err != nil {
+			os.Exit(exitUsage)
+		}
+	}
+
+	if opts.PruneCache > 0 {
+		if err := pruneCache(opts); err != nil {
+			log.Println("Error pruning cache:", err)
+			os.Exit(exitFailed)
+		}
+	}
+
+	if len(remaining) < 1 && opts.Target == "" {
+		target := defaultTargetFor(opts, cfg)
+		if target == "" {
+			if opts.PruneCache > 0 {
+				// pruning the cache was all there was to do
+				os.Exit(exitOK)
+			}
+			p.WriteHelp(os.Stdout)
+			os.Exit(exitUsage)
+		}
+		defaults.Target = target
 	}
 
-	procs, err := handleCommandLine(os.Args[1:], opts)
+	procs, err := handleCommandLine(os.Args[1:], defaults, scope{cfg: cfg})
 	if err != nil {
-		p.WriteHelp(os.Stdout)
-		os.Exit(1)
+		log.Println(err)
+		os.Exit(exitUsage)
 	}
+	procs = dedupe(procs)
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(procs))
+	// stop any running generators if we're interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	jobs := opts.Jobs
+	if opts.Serial || jobs < 1 {
+		jobs = 1
+	}
+	if err := checkReport(opts, procs); err != nil {
+		log.Println(err)
+		os.Exit(exitUsage)
+	}
+	if opts.Report != "" && opts.ReportFile == "" {
+		// keep stdout clean for the report
+		for _, p := range procs {
+			if p.Writer() == os.Stdout {
+				p.SetOutput(os.Stderr)
+			}
+		}
+	}
+
+	errs := runAll(ctx, procs, jobs)
+	stop()
+
+	reports := make([]processor.FileReport, len(procs))
+	for i, p := range procs {
+		reports[i] = p.Report(errs[i])
+	}
+	r := summarize(reports)
+	if !opts.Quiet {
+		log.Printf("%d processed, %d unchanged, %d with no gocog code, %d failed", r.Processed, r.Unchanged, r.NoCog, r.Failed)
+	}
+	if opts.Report != "" {
+		if err := writeReport(r, opts.ReportFile); err != nil {
+			log.Println("Error writing report:", err)
+			os.Exit(exitFailed)
+		}
+	}
+
+	os.Exit(exitStatus(reports, errs))
+}
+
+// exitStatus returns the status to exit with for the results of running the files.
+// A malformed block in any file takes precedence over other failures.
+func exitStatus(reports []processor.FileReport, errs []error) int {
+	status := exitOK
+	for i, r := range reports {
+		if r.Status != processor.StatusFailed {
+			continue
+		}
+		var syntaxErr *processor.SyntaxError
+		if errors.As(errs[i], &syntaxErr) {
+			return exitSyntax
+		}
+		status = exitFailed
+	}
+	return status
+}
+
+// checkReport returns an error if the report would be written to stdout along with the output
+// of a file or a diff, which would leave neither of them readable.
+func checkReport(opts processor.Options, procs []*processor.Processor) error {
+	if opts.Report == "" || opts.ReportFile != "" {
+		return nil
+	}
 	for _, p := range procs {
-		if opts.Serial {
-			run(p, wg)
-		} else {
-			go run(p, wg)
+		if p.ToStdout || p.File == "-" || p.Diff {
+			return errors.New("The report can't be written to stdout along with the output or a diff, use --report-file")
 		}
 	}
+	return nil
+}
+
+// defaultTargetFor returns the target to run when the command line names no files or target, which is
+// the config's default target, or an empty string if there's nothing to run. Pruning the cache on its
+// own never runs the default target, since no files were asked for.
+func defaultTargetFor(opts processor.Options, cfg *config) string {
+	if opts.PruneCache > 0 || cfg == nil || cfg.Targets[defaultTarget] == nil {
+		return ""
+	}
+	return defaultTarget
+}
+
+// pruneCache removes the cached generator output that hasn't been used for the age given in the options.
+func pruneCache(opts processor.Options) error {
+	dir := opts.CacheDir
+	if dir == "" {
+		d, err := processor.DefaultCacheDir()
+		if err != nil {
+			return err
+		}
+		dir = d
+	}
+	removed, err := processor.PruneCache(dir, opts.PruneCache)
+	if err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		log.Printf("Removed %d cached results from '%s'", removed, dir)
+	}
+	return nil
+}
+
+// runAll runs the processors with at most jobs of them running at once,
+// and returns the error from each one.
+func runAll(ctx context.Context, procs []*processor.Processor, jobs int) []error {
+	errs := make([]error, len(procs))
+	work := make(chan int)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(jobs)
+	for w := 0; w < jobs; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = run(ctx, procs[i])
+			}
+		}()
+	}
+
+	for i := range procs {
+		work <- i
+	}
+	close(work)
 	wg.Wait()
+	return errs
 }
 
-// run initiates processing and then signals the waitgroup when finished
-func run(p *processor.Processor, wg *sync.WaitGroup) {
-	if err := p.Run(); err != nil {
+// run initiates processing and returns the result
+func run(ctx context.Context, p *processor.Processor) error {
+	err := p.Run(ctx)
+	var syntaxErr *processor.SyntaxError
+	switch {
+	case err == nil, err == processor.OutOfDate:
+	case errors.As(err, &syntaxErr):
+		// leave off the timestamp, so editors and CI tools can parse the error's location
+		if !p.Quiet {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	default:
 		p.Println(err)
 	}
-	wg.Done()
+	return err
+}
+
+// runReport describes a whole run of gocog, and is what gets written out with --report.
+type runReport struct {
+	Processed int                    `json:"processed"`
+	Unchanged int                    `json:"unchanged"`
+	NoCog     int                    `json:"nocog"`
+	Failed    int                    `json:"failed"`
+	Files     []processor.FileReport `json:"files"`
+}
+
+// summarize counts how many files were processed, left unchanged, had no gocog code or failed.
+func summarize(files []processor.FileReport) runReport {
+	r := runReport{Files: files}
+	for _, f := range files {
+		switch f.Status {
+		case processor.StatusProcessed:
+			r.Processed++
+		case processor.StatusUnchanged:
+			r.Unchanged++
+		case processor.StatusNoCog:
+			r.NoCog++
+		default:
+			r.Failed++
+		}
+	}
+	return r
+}
+
+// writeReport writes the report as JSON to the named file, or to stdout if name is empty.
+func writeReport(r runReport, name string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if name == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(name, b, 0666)
+}
+
+// scope is what a command line passes on to the files, filelists and directories it names.
+type scope struct {
+	cfg *config
+	// set holds the long names of the options given on this and the enclosing command lines,
+	// which overrides in the config don't change
+	set map[string]bool
+	// dir is the directory of the filelist the command line is in, which relative paths are resolved against.
+	// It's empty for the command line gocog was run with.
+	dir string
+	// filelists holds the absolute paths of the filelists being read, to catch filelists that include themselves
+	filelists []string
 }
 
 // handleCommandLine parses the args into options and creates Processors from the files and filelists.
 // Will return an error if no files or filelists are on the command line.
 // args is expected not to contain the executable name.
-func handleCommandLine(args []string, opts processor.Options) ([]*processor.Processor, error) {
+func handleCommandLine(args []string, opts processor.Options, s scope) ([]*processor.Processor, error) {
 	p := flags.NewParser(&opts, flags.Default)
 
+	inherited := opts.Define
 	remaining, err := p.ParseArgs(args)
 	if err != nil {
 		return nil, err
 	}
+	opts.Define = mergeDefines(inherited, opts.Define)
+
+	given := map[string]bool{}
+	for name := range s.set {
+		given[name] = true
+	}
+	setOptions(p.Command.Group, given)
+	s.set = given
+
+	if s.dir != "" {
+		if remaining, err = resolveNames(remaining, s.dir); err != nil {
+			return nil, err
+		}
+		// paths in options given on a filelist line are relative to the filelist too
+		if p.FindOptionByLongName("output").IsSet() {
+			opts.OutFile = resolvePath(opts.OutFile, s.dir)
+		}
+		if p.FindOptionByLongName("include-path").IsSet() {
+			for i, path := range opts.Include {
+				opts.Include[i] = resolvePath(path, s.dir)
+			}
+		}
+	}
+
+	if opts.Target != "" {
+		targets, err := s.cfg.target(opts.Target)
+		if err != nil {
+			return nil, err
+		}
+		remaining = append(remaining, targets...)
+		// filelists in the target mustn't run it again
+		opts.Target = ""
+	}
 
 	if len(remaining) < 1 {
 		return nil, errors.New("No files targeted on command line")
 	}
 
-	if len(opts.Ext) > 0 && opts.Ext[:1] != "." {
-		opts.Ext = "." + opts.Ext
+	if opts.OutFile != "" && (len(remaining) > 1 || remaining[0][:1] == "@" || isRecursive(remaining[0])) {
+		return nil, errors.New("An output file can only be given for a single input file")
+	}
+
+	stdout := opts.ToStdout
+	for _, name := range remaining {
+		stdout = stdout || name == "-"
+	}
+	if stdout && (len(remaining) > 1 || remaining[0][:1] == "@" || isRecursive(remaining[0])) {
+		return nil, errors.New("Output can only be written to stdout for a single input file")
 	}
+	if stdout && opts.OutFile != "" {
+		return nil, errors.New("An output file can't be given when writing the output to stdout")
+	}
+
+	opts.Ext = processor.NormalizeExt(opts.Ext)
 
-	return handleRemaining(remaining, &opts)
+	return handleRemaining(remaining, &opts, s)
+}
+
+// setOptions adds the long names of the options set in the group, and the groups in it, to set.
+func setOptions(g *flags.Group, set map[string]bool) {
+	for _, o := range g.Options() {
+		if o.IsSet() {
+			set[o.LongName] = true
+		}
+	}
+	for _, child := range g.Groups() {
+		setOptions(child, set)
+	}
 }
 
-// handleRemaining creates processors from the files and filelists with the given options.
-func handleRemaining(names []string, opts *processor.Options) ([]*processor.Processor, error) {
+// resolveNames resolves the files, filelists and directories named on a filelist line relative to the
+// filelist's directory, and expands any globs among them. A glob that matches nothing is an error.
+func resolveNames(names []string, dir string) ([]string, error) {
+	var out []string
+	for _, name := range names {
+		prefix := ""
+		if name[:1] == "@" {
+			prefix, name = "@", name[1:]
+		}
+		if name == "-" {
+			out = append(out, prefix+name)
+			continue
+		}
+		name = resolvePath(name, dir)
+		if isRecursive(name) || !strings.ContainsAny(name, "*?[") {
+			out = append(out, prefix+name)
+			continue
+		}
+		matches, err := filepath.Glob(name)
+		if err != nil {
+			return nil, fmt.Errorf("Bad glob '%s': %s", name, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match '%s'", name)
+		}
+		for _, m := range matches {
+			out = append(out, prefix+m)
+		}
+	}
+	return out, nil
+}
+
+// resolvePath returns the path relative to dir, unless it's absolute.
+func resolvePath(path, dir string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// mergeDefines returns the defines given on a command line added to the ones inherited from
+// the enclosing command line, rather than replacing them.
+func mergeDefines(inherited, given processor.Defines) processor.Defines {
+	if len(inherited) == 0 {
+		return given
+	}
+	defines := processor.Defines{}
+	for name, value := range inherited {
+		defines[name] = value
+	}
+	for name, value := range given {
+		defines[name] = value
+	}
+	return defines
+}
+
+// handleRemaining creates processors from the files, filelists and directories with the given options.
+func handleRemaining(names []string, opts *processor.Options, s scope) ([]*processor.Processor, error) {
 	procs := make([]*processor.Processor, 0, len(names))
-	for _, s := range names {
-		if s[:1] == "@" {
-			p, err := handleFilelist(s[1:], opts)
+	for _, name := range names {
+		switch {
+		case name[:1] == "@":
+			p, err := handleFilelist(name[1:], opts, s)
+			if err != nil {
+				return nil, err
+			}
+			procs = append(procs, p...)
+		case isRecursive(name):
+			p, err := handleDir(strings.TrimSuffix(filepath.ToSlash(name), "..."), opts, s)
 			if err != nil {
 				return nil, err
 			}
 			procs = append(procs, p...)
-		} else {
-			procs = append(procs, processor.New(s, opts))
+		default:
+			p, err := newProcessor(name, opts, s)
+			if err != nil {
+				return nil, err
+			}
+			procs = append(procs, p)
 		}
 	}
 	return procs, nil
 }
 
-// handleFilelist reads the file given and handles each non-blank line as a command line for gocog.
-func handleFilelist(name string, opts *processor.Options) ([]*processor.Processor, error) {
+// newProcessor creates a processor for the file, with the config's overrides for the file applied.
+func newProcessor(file string, opts *processor.Options, s scope) (*processor.Processor, error) {
+	o, err := s.cfg.optionsFor(file, opts, s.set)
+	if err != nil {
+		return nil, err
+	}
+	return processor.New(file, o), nil
+}
+
+// isRecursive reports whether the name is a DIR/... pattern for all the files under DIR.
+func isRecursive(name string) bool {
+	name = filepath.ToSlash(name)
+	return name == "..." || strings.HasSuffix(name, "/...")
+}
+
+// handleDir creates processors for every file under the directory that has gocog code in it.
+func handleDir(dir string, opts *processor.Options, s scope) ([]*processor.Processor, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if opts.Verbose {
+		log.Printf("Searching directory '%s'", dir)
+	}
+	// files with overrides in the config may have their own start mark
+	optionsFor := func(path string) (*processor.Options, error) {
+		return s.cfg.optionsFor(path, opts, s.set)
+	}
+	files, skipped, err := processor.Walk(filepath.FromSlash(dir), opts, optionsFor)
+	if err != nil {
+		return nil, fmt.Errorf("Error searching directory '%s': %s", dir, err)
+	}
+	if opts.Verbose {
+		for _, f := range skipped {
+			log.Printf("Skipping '%s', it has no gocog code", f)
+		}
+	}
+	procs := make([]*processor.Processor, len(files))
+	for i, f := range files {
+		if procs[i], err = newProcessor(f, opts, s); err != nil {
+			return nil, err
+		}
+	}
+	return procs, nil
+}
+
+// handleFilelist reads the file given and handles each line as a command line for gocog.
+// Blank lines and lines starting with # are skipped. Paths on each line are relative to the filelist.
+func handleFilelist(name string, opts *processor.Options, s scope) ([]*processor.Processor, error) {
 	if opts.Verbose {
 		log.Printf("Processing filelist '%s'", name)
 	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range s.filelists {
+		if f == abs {
+			cycle := append(append([]string{}, s.filelists[i:]...), abs)
+			return nil, fmt.Errorf("Filelist '%s' includes itself: %s", name, strings.Join(cycle, " -> "))
+		}
+	}
 	b, err := os.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
 	lines := strings.SplitAfter(string(b), "\n")
 
+	s.dir = filepath.Dir(name)
+	s.filelists = append(s.filelists[:len(s.filelists):len(s.filelists)], abs)
 	procs := make([]*processor.Processor, 0, len(lines))
 	for i, line := range lines {
-		if len(line) == 0 {
+		if trimmed := strings.TrimSpace(line); trimmed == "" || trimmed[0] == '#' {
 			continue
 		}
 		args, err := shellquote.Split(line)
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing command line in filelist '%s' line %d", name, i+1)
 		}
-		p, err := handleCommandLine(args, *opts)
+		p, err := handleCommandLine(args, *opts, s)
 		if err != nil {
 			return nil, err
 		}
@@ -161,3 +581,28 @@ func handleFilelist(name string, opts *processor.Options) ([]*processor.Processo
 	}
 	return procs, nil
 }
+
+// dedupe drops the processors that write to the same file as an earlier one, so that they don't race
+// on the file's output. The same file can be reached through more than one filelist or directory.
+func dedupe(procs []*processor.Processor) []*processor.Processor {
+	seen := map[string]bool{}
+	out := procs[:0]
+	for _, p := range procs {
+		dest := p.OutFile
+		if dest == "" {
+			dest = p.File
+		}
+		if dest != "-" {
+			abs, err := filepath.Abs(dest)
+			if err == nil && seen[abs] {
+				if p.Verbose {
+					p.Printf("Skipping '%s', it's already being processed", p.File)
+				}
+				continue
+			}
+			seen[abs] = true
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"gocog/processor"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type FilelistData struct {
+	args  []string
+	files []string
+	// outs and includes are the output file and comma separated include paths of each processor
+	outs     []string
+	includes []string
+	// err is part of the error expected, if any
+	err string
+}
+
+func TestHandleFilelist(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"lists/main.txt":          "# a comment\n\n   \n\t\na.go\n  # an indented comment\ngen/*.go -I inc -I /abs\n@nested/more.txt\nb.go -o out/b.go\n",
+		"lists/a.go":              "",
+		"lists/b.go":              "",
+		"lists/gen/x.go":          "",
+		"lists/gen/y.go":          "",
+		"lists/nested/more.txt":   "c.go\n@../deeper/last.txt\n",
+		"lists/nested/c.go":       "",
+		"lists/deeper/last.txt":   "../a.go -o a.out\n",
+		"lists/nomatch.txt":       "none/*.go\n",
+		"lists/self.txt":          "a.go\n@self.txt\n",
+		"lists/cycle1.txt":        "@nested/cycle2.txt\n",
+		"lists/nested/cycle2.txt": "@../cycle1.txt\n",
+	})
+	chdir(t, root)
+	lists := func(names ...string) []string {
+		out := make([]string, len(names))
+		for i, name := range names {
+			if name != "" && !filepath.IsAbs(name) {
+				name = filepath.Join("lists", filepath.FromSlash(name))
+			}
+			out[i] = name
+		}
+		return out
+	}
+
+	tests := []FilelistData{
+		{
+			[]string{"@lists/main.txt"},
+			lists("a.go", "gen/x.go", "gen/y.go", "nested/c.go", "a.go", "b.go"),
+			lists("", "", "", "", "deeper/a.out", "out/b.go"),
+			[]string{"", filepath.Join("lists", "inc") + ",/abs", filepath.Join("lists", "inc") + ",/abs", "", "", ""},
+			"",
+		},
+		// a glob on the command line gocog was run with is left to the shell
+		{[]string{"lists/gen/x.go"}, lists("gen/x.go"), lists(""), []string{""}, ""},
+		{[]string{"@lists/nomatch.txt"}, nil, nil, nil, "No files match"},
+		{[]string{"@lists/self.txt"}, nil, nil, nil, "includes itself"},
+		{[]string{"@lists/cycle1.txt"}, nil, nil, nil, "cycle1.txt -> " + filepath.Join(root, "lists", "nested", "cycle2.txt")},
+		{[]string{"@lists/missing.txt"}, nil, nil, nil, "missing.txt"},
+	}
+
+	for i, test := range tests {
+		procs, err := handleCommandLine(test.args, testDefaults(), scope{})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("HandleFilelist Test %d: Expected error containing '%s', Got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("HandleFilelist Test %d: Unexpected error: %v", i, err)
+			continue
+		}
+		var files, outs, includes []string
+		for _, p := range procs {
+			files = append(files, p.File)
+			outs = append(outs, p.OutFile)
+			includes = append(includes, strings.Join(p.Include, ","))
+		}
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("HandleFilelist Test %d: Expected files %q, Got %q", i, test.files, files)
+		}
+		if !reflect.DeepEqual(outs, test.outs) {
+			t.Errorf("HandleFilelist Test %d: Expected output files %q, Got %q", i, test.outs, outs)
+		}
+		if !reflect.DeepEqual(includes, test.includes) {
+			t.Errorf("HandleFilelist Test %d: Expected include paths %q, Got %q", i, test.includes, includes)
+		}
+	}
+}
+
+type DedupeData struct {
+	args  []string
+	files []string
+	// from is the FROM define of each processor that's kept
+	from []string
+}
+
+func TestDedupe(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.go":           "",
+		"b.go":           "",
+		"first.txt":      "a.go -D FROM=first\n@sub/second.txt\n",
+		"sub/second.txt": "../a.go -D FROM=second\n../b.go -o ../a.go\n../b.go\n",
+	})
+	chdir(t, root)
+
+	tests := []DedupeData{
+		{[]string{"a.go", "b.go"}, []string{"a.go", "b.go"}, []string{"", ""}},
+		{[]string{"a.go", "./a.go", filepath.Join(root, "a.go")}, []string{"a.go"}, []string{""}},
+		// the first line that names a file wins, along with its options, and a file
+		// written to with -o counts as named
+		{[]string{"@first.txt"}, []string{"a.go", "b.go"}, []string{"first", ""}},
+	}
+
+	for i, test := range tests {
+		all, err := handleCommandLine(test.args, testDefaults(), scope{})
+		if err != nil {
+			t.Errorf("Dedupe Test %d: Unexpected error: %v", i, err)
+			continue
+		}
+		var files, from []string
+		for _, p := range dedupe(all) {
+			files = append(files, p.File)
+			from = append(from, p.Define["FROM"])
+		}
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("Dedupe Test %d: Expected files %q, Got %q", i, test.files, files)
+		}
+		if !reflect.DeepEqual(from, test.from) {
+			t.Errorf("Dedupe Test %d: Expected FROM defines %q, Got %q", i, test.from, from)
+		}
+	}
+}
+
+type ExitStatusData struct {
+	statuses []string
+	errs     []error
+	status   int
+}
+
+func TestExitStatus(t *testing.T) {
+	syntaxErr := &processor.SyntaxError{File: "a.go", Line: 3, Msg: "Unexpected EOF", Err: io.ErrUnexpectedEOF}
+	genErr := errors.New("Error running generator")
+	tests := []ExitStatusData{
+		{nil, nil, exitOK},
+		{[]string{processor.StatusProcessed, processor.StatusNoCog}, []error{nil, processor.NoCogCode}, exitOK},
+		{[]string{processor.StatusProcessed, processor.StatusFailed}, []error{nil, genErr}, exitFailed},
+		{[]string{processor.StatusFailed}, []error{processor.OutOfDate}, exitFailed},
+		{[]string{processor.StatusFailed}, []error{syntaxErr}, exitSyntax},
+		{[]string{processor.StatusFailed, processor.StatusFailed}, []error{genErr, syntaxErr}, exitSyntax},
+		{[]string{processor.StatusFailed, processor.StatusFailed}, []error{syntaxErr, genErr}, exitSyntax},
+	}
+
+	for i, test := range tests {
+		reports := make([]processor.FileReport, len(test.statuses))
+		for j, status := range test.statuses {
+			reports[j].Status = status
+		}
+		if status := exitStatus(reports, test.errs); status != test.status {
+			t.Errorf("ExitStatus Test %d: Expected status %d, Got %d", i, test.status, status)
+		}
+	}
+}
+
+type CheckReportData struct {
+	args  []string
+	fails bool
+}
+
+func TestCheckReport(t *testing.T) {
+	tests := []CheckReportData{
+		{[]string{"a.go"}, false},
+		{[]string{"--report", "json", "a.go", "b.go"}, false},
+		{[]string{"--report", "json", "--stdout", "a.go"}, true},
+		{[]string{"--report", "json", "-"}, true},
+		{[]string{"--report", "json", "--diff", "a.go"}, true},
+		{[]string{"--report", "json", "--report-file", "report.json", "-"}, false},
+		{[]string{"--stdout", "a.go"}, false},
+	}
+
+	for i, test := range tests {
+		opts := testDefaults()
+		procs, err := handleCommandLine(test.args, opts, scope{})
+		if err != nil {
+			t.Errorf("CheckReport Test %d: Unexpected error: %v", i, err)
+			continue
+		}
+		// the report options are read from the command line gocog was run with
+		opts = *procs[0].Options
+		if err := checkReport(opts, procs); test.fails != (err != nil) {
+			t.Errorf("CheckReport Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+	}
+}
+
+type DefaultTargetData struct {
+	prune  time.Duration
+	cfg    *config
+	target string
+}
+
+func TestDefaultTargetFor(t *testing.T) {
+	withDefault := &config{Targets: map[string][]string{defaultTarget: {"..."}}}
+	withoutDefault := &config{Targets: map[string][]string{"docs": {"README.md"}}}
+	tests := []DefaultTargetData{
+		{0, nil, ""},
+		{0, withoutDefault, ""},
+		{0, withDefault, defaultTarget},
+		// pruning the cache on its own doesn't process any files
+		{720 * time.Hour, withDefault, ""},
+		{720 * time.Hour, nil, ""},
+	}
+
+	for i, test := range tests {
+		opts := testDefaults()
+		opts.PruneCache = test.prune
+		if target := defaultTargetFor(opts, test.cfg); target != test.target {
+			t.Errorf("DefaultTargetFor Test %d: Expected target '%s', Got '%s'", i, test.target, target)
+		}
+	}
+}
@@ -22,12 +22,14 @@ Package main creates an executable that will generate text from inline sourcecod
 
 Usage:
 
-	gocog [OPTIONS] [INFILE | @FILELIST] ...
+	gocog [OPTIONS] [INFILE | @FILELIST | DIR/...] ...
 
-	Runs gocog over each infile.
+	Runs gocog over each infile. An infile of - is read from stdin and written to stdout.
 	Strings prepended with @ are assumed to be files continaing newline delimited lists of gocog command lines.
 	Command line options are passed to each command line in the file list, but options on the file list line
 	will override command line options. You may have filelists specified inside filelist files.
+	DIR/... processes every file under DIR with gocog code in it, skipping files ignored by .gitignore or .gocogignore.
+	Exits with 1 if any file failed, 2 if the command line or a filelist is bad, and 3 if any file has a malformed gocog block.
 
 Help Options:
 
@@ -38,7 +40,10 @@ Application Options:
 	-z, --eof          The end marker can be assumed at eof.
 	-v, --verbose      enables verbose output
 	-q, --quiet        turns off all output
-	-S, --serial       Write to the specified cog files serially
+	-S, --serial       Write to the specified cog files serially, same as
+	                   --jobs=1
+	-j, --jobs         The number of files to process at once (the number of
+	                   CPUs)
 	-c, --cmd          The command used to run the generator code (go)
 	-a, --args         Comma separated arguments to cmd, %s for the code file
 	                   ([run, %s])
@@ -48,5 +53,47 @@ Application Options:
 	-x, --excise       Excise all the generated output without running the
 	                   generators.
 	-V, --version      Display the version of gocog
+	    --checksum     Checksum the output to protect it against accidental
+	                   change.
+	-f, --force        Overwrite generated output even if it was changed
+	                   since it was checksummed.
+	    --check        Check that the generated output is up to date without
+	                   writing any files.
+	    --diff         Print a unified diff of the changes to each file
+	                   without writing any files.
+	-d, --delete       Delete the generator code from the output file.
+	-o, --output       Write the output to OUTNAME instead of overwriting
+	                   the input file.
+	-D, --define       Define a global string available to your generator
+	                   code as an environment variable.
+	-I, --include-path Add PATH to the list of directories for data files
+	                   and modules.
+	-s, --suffix       Suffix all generated output lines with STRING.
+	-U, --unix         Write the output with Unix newlines (only LF line-
+	                   endings).
+	-w, --writecmd     Use CMD if the output file needs to be made writable.
+	                   A %s in the CMD will be filled with the filename.
+	    --timeout      Kill generators that run for longer than DURATION,
+	                   e.g. 30s. A block can override this with
+	                   timeout=DURATION after its start mark.
+	    --report       Write a report of every file and block processed, in
+	                   the given format (json)
+	    --report-file  Write the report to FILE instead of stdout
+	    --stdout       Write the output to stdout instead of overwriting the
+	                   input file. An INFILE of - is read from stdin.
+	    --include      Only process files matching GLOB when searching a
+	                   DIR/... for files
+	    --exclude      Skip files and directories matching GLOB when
+	                   searching a DIR/... for files
+	    --config       Read the project config from FILE instead of
+	                   searching for .gocog.json
+	    --target       Process the files of the target NAME from the project
+	                   config
+	    --cache-dir    Cache generator output in DIR instead of the user's
+	                   cache directory
+	    --no-cache     Run every generator instead of reusing the output
+	                   cached from an earlier run
+	    --prune-cache  Remove cached output that hasn't been used for AGE,
+	                   e.g. 720h
 */
 package main
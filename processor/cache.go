@@ -0,0 +1,180 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the version of the cache key, to change when the way keys are computed changes
+const cacheVersion = "gocog cache 1"
+
+// DefaultCacheDir returns the directory generator output is cached in when no cache directory is given,
+// which is gocog under the user's cache directory, e.g. $XDG_CACHE_HOME/gocog.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gocog"), nil
+}
+
+// cacheDir returns the directory the Processor caches generator output in.
+func (p *Processor) cacheDir() (string, error) {
+	if p.CacheDir != "" {
+		return p.CacheDir, nil
+	}
+	return DefaultCacheDir()
+}
+
+// cacheKey returns the key the output of the block being processed is cached under. It's a hash of
+// everything that goes into running the generator: the file, the language, the generator code,
+// the defines, the include paths and the contents of the files under them, and the names and contents
+// of the input files declared on the block's start line.
+func (p *Processor) cacheKey(lang Language, code []string) (string, error) {
+	file, err := filepath.Abs(p.File)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	field := func(values ...string) {
+		for _, v := range values {
+			fmt.Fprintf(h, "%d:%s", len(v), v)
+		}
+		h.Write([]byte{0})
+	}
+	field(cacheVersion)
+	field(file)
+	field(p.block.name, lang.Command, lang.Ext)
+	field(lang.Args...)
+	field(code...)
+	field(p.Define.Environ()...)
+	field(p.Include...)
+	for _, dir := range p.Include {
+		if err := hashDir(h, dir); err != nil {
+			return "", fmt.Errorf("Error reading include path '%s': %s", dir, err)
+		}
+	}
+	for _, input := range p.block.inputs {
+		if err := hashInput(h, filepath.Join(filepath.Dir(p.File), input)); err != nil {
+			return "", fmt.Errorf("%s:%d: Error reading input file of block: %s", p.File, p.start, err)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// hashInput adds the name and contents of the input file to the hash.
+func hashInput(h hash.Hash, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%d:%s%d:", len(name), name, info.Size())
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// hashDir adds the names and contents of the files under the directory to the hash, in lexical order,
+// so that editing helper code in an include path means running the generators that may use it again.
+func hashDir(h hash.Hash, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		return hashInput(h, path)
+	})
+}
+
+// cachePath returns the path of the cache entry with the given key.
+func (p *Processor) cachePath(key string) (string, error) {
+	dir, err := p.cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, key[:2], key), nil
+}
+
+// cacheGet returns the output cached under the key, if there is any.
+// The entry is marked as used, so pruning the cache keeps it.
+func (p *Processor) cacheGet(key string) ([]byte, bool) {
+	if key == "" {
+		return nil, false
+	}
+	path, err := p.cachePath(key)
+	if err != nil {
+		return nil, false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return b, true
+}
+
+// cachePut caches the output under the key. The entry is written to a temporary file first,
+// so other runs of gocog never see a partly written entry.
+func (p *Processor) cachePut(key string, output []byte) error {
+	path, err := p.cachePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(output); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// PruneCache removes the output cached in dir that hasn't been used for the given age,
+// and returns the number of entries removed. A missing cache directory has nothing to prune.
+// Temporary files left behind by runs that were killed are removed once they're as old,
+// which leaves alone the ones that running copies of gocog are still writing.
+func PruneCache(dir string, age time.Duration) (removed int, err error) {
+	cutoff := time.Now().Add(-age)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenCache(t *testing.T) {
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("one\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Command: "sh", Args: []string{"%s"}, Ext: ".sh", StartMark: "[[[", EndMark: "]]]", Quiet: true,
+		Cache: true, CacheDir: filepath.Join(dir, "cache"), Define: Defines{"RUNS": runs}}
+	p := New(filepath.Join(dir, "foo"), opts)
+
+	// the generator counts its runs, so we can tell when the cached output is used instead
+	code := "# [[[gocog inputs=input.txt\n# echo run >> \"$RUNS\"\n# cat \"$(dirname \"$RUNS\")/input.txt\"\n# gocog]]]\n# [[[end]]]\n"
+	gen := func() string {
+		out := &bytes.Buffer{}
+		if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(code)), out); err != io.EOF {
+			t.Fatalf("GenCache: Expected error %v, got %v", io.EOF, err)
+		}
+		return out.String()
+	}
+	countRuns := func() int {
+		b, _ := os.ReadFile(runs)
+		return strings.Count(string(b), "run")
+	}
+
+	first := gen()
+	if !strings.Contains(first, "one\n") || countRuns() != 1 || p.blocks[0].Cached {
+		t.Errorf("GenCache: Expected the generator to run once, ran %d times with output:\n'%s'", countRuns(), first)
+	}
+	command := p.blocks[0].Command
+	if second := gen(); second != first || countRuns() != 1 || !p.blocks[0].Cached {
+		t.Errorf("GenCache: Expected the cached output to be used, ran %d times with output:\n'%s'", countRuns(), second)
+	}
+	if cached := p.blocks[0].Command; len(cached) == 0 || fmt.Sprint(cached) != fmt.Sprint(command) {
+		t.Errorf("GenCache: Expected cached block to report command %q, got %q", command, cached)
+	}
+
+	// changing an input file means running the generator again
+	if err := os.WriteFile(input, []byte("two\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if third := gen(); !strings.Contains(third, "two\n") || countRuns() != 2 {
+		t.Errorf("GenCache: Expected the generator to run again after its input changed, ran %d times with output:\n'%s'", countRuns(), third)
+	}
+
+	// so does changing a define
+	opts.Define["OTHER"] = "x"
+	gen()
+	if countRuns() != 3 {
+		t.Errorf("GenCache: Expected the generator to run again after a define changed, ran %d times", countRuns())
+	}
+
+	// as does changing a file under an include path, which generators may load helper code from
+	inc := filepath.Join(dir, "inc")
+	helper := filepath.Join(inc, "helper.sh")
+	if err := os.MkdirAll(inc, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(helper, []byte("echo one\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	opts.Include = []string{inc}
+	gen()
+	gen()
+	if countRuns() != 4 {
+		t.Errorf("GenCache: Expected the generator to run once with an include path, ran %d times in all", countRuns())
+	}
+	if err := os.WriteFile(helper, []byte("echo two\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	gen()
+	if countRuns() != 5 {
+		t.Errorf("GenCache: Expected the generator to run again after a file under an include path changed, ran %d times", countRuns())
+	}
+
+	// and the cache can be bypassed
+	opts.NoCache = true
+	gen()
+	if countRuns() != 6 {
+		t.Errorf("GenCache: Expected the generator to run with --no-cache, ran %d times", countRuns())
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "ab", "abcd")
+	recent := filepath.Join(dir, "cd", "cdef")
+	// temporary files are only pruned when they're old, since a running gocog may be about to rename them
+	oldTemp := filepath.Join(dir, "ab", "abce.123.tmp")
+	recentTemp := filepath.Join(dir, "cd", "cdeg.456.tmp")
+	for _, name := range []string{old, recent, oldTemp, recentTemp} {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("output\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	then := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{old, oldTemp} {
+		if err := os.Chtimes(name, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := PruneCache(dir, 24*time.Hour)
+	if err != nil || removed != 2 {
+		t.Errorf("PruneCache: Expected 2 entries removed, got %d, %v", removed, err)
+	}
+	for _, name := range []string{old, oldTemp} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("PruneCache: Expected old entry '%s' to be removed, got %v", name, err)
+		}
+	}
+	for _, name := range []string{recent, recentTemp} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("PruneCache: Expected recent entry '%s' to be kept, got %v", name, err)
+		}
+	}
+
+	if removed, err := PruneCache(filepath.Join(dir, "missing"), time.Hour); err != nil || removed != 0 {
+		t.Errorf("PruneCache: Expected nothing to prune in a missing directory, got %d, %v", removed, err)
+	}
+}
@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// number of unchanged lines shown around each change in a unified diff
+const diffContext = 3
+
+// edit is a single step in an edit script turning one list of lines into another.
+// op is ' ' for a line in both, '-' for a line only in the old list and '+' for a line only in the new list.
+// a and b are the indexes of the line in the old and new lists.
+type edit struct {
+	op   byte
+	a, b int
+}
+
+// unifiedDiff returns a unified diff between the old and new contents of the named file.
+// If the contents are the same, nil is returned.
+func unifiedDiff(name string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	a := splitLines(string(old))
+	b := splitLines(string(new))
+	edits := diffLines(a, b)
+
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", name, name)
+	for start := 0; start < len(edits); {
+		// find the next change
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// extend the hunk until there's a run of unchanged lines too long to bridge
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].op != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		last := end + diffContext
+		if last > len(edits) {
+			last = len(edits)
+		}
+		writeHunk(out, a, b, edits[first:last])
+		start = last
+	}
+	return out.Bytes()
+}
+
+// writeHunk writes a single hunk of a unified diff, including its header.
+func writeHunk(out *bytes.Buffer, a, b []string, edits []edit) {
+	aStart, bStart := edits[0].a, edits[0].b
+	aCount, bCount := 0, 0
+	for _, e := range edits {
+		if e.op != '+' {
+			aCount++
+		}
+		if e.op != '-' {
+			bCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+
+	for _, e := range edits {
+		line := ""
+		switch e.op {
+		case '+':
+			line = b[e.b]
+		default:
+			line = a[e.a]
+		}
+		out.WriteByte(e.op)
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the line range of one side of a hunk header.
+// start is the zero based index of the first line of the range.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits s into lines, each keeping its line ending.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script that turns a into b, using the linear space version of
+// Myers' algorithm, which splits the lists at the middle of the edit script and diffs each half in turn.
+// Lines the lists start or end with in common are matched up front, which is most of a typical file.
+func diffLines(a, b []string) []edit {
+	off := (len(a)+len(b)+1)/2 + 1
+	d := &differ{
+		a:     a,
+		b:     b,
+		off:   off,
+		vf:    make([]int, 2*off+1),
+		vb:    make([]int, 2*off+1),
+		edits: make([]edit, 0, len(a)+len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+// differ holds the state of diffLines. vf and vb hold the furthest x reached on each diagonal
+// by the forward and backward searches for the middle of an edit script, offset by off.
+type differ struct {
+	a, b   []string
+	off    int
+	vf, vb []int
+	edits  []edit
+}
+
+// compare adds the edits that turn a[a0:a1] into b[b0:b1].
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.edits = append(d.edits, edit{' ', a0, b0})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a1-suffix > a0 && b1-suffix > b0 && d.a[a1-suffix-1] == d.b[b1-suffix-1] {
+		suffix++
+	}
+	a1 -= suffix
+	b1 -= suffix
+
+	switch {
+	case a0 == a1:
+		for y := b0; y < b1; y++ {
+			d.edits = append(d.edits, edit{'+', a0, y})
+		}
+	case b0 == b1:
+		for x := a0; x < a1; x++ {
+			d.edits = append(d.edits, edit{'-', x, b0})
+		}
+	default:
+		// with the common ends gone and lines left on both sides, there are at least two edits,
+		// so both halves are smaller than the whole
+		x, y, u, v := d.middleSnake(a0, a1, b0, b1)
+		d.compare(a0, x, b0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, edit{' ', x, y})
+		}
+		d.compare(u, a1, v, b1)
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.edits = append(d.edits, edit{' ', a1 + i, b1 + i})
+	}
+}
+
+// middleSnake finds the run of matching lines in the middle of the shortest edit script that turns
+// a[a0:a1] into b[b0:b1], by searching forward from the start and backward from the end at once
+// until the searches meet. The run goes from a[x], b[y] up to a[u], b[v] and may be empty.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	off := d.off
+	d.vf[off+1] = 0
+	d.vb[off+1] = 0
+
+	for D := 0; D <= (n+m+1)/2; D++ {
+		for k := -D; k <= D; k += 2 {
+			var fx int
+			if k == -D || (k != D && d.vf[off+k-1] < d.vf[off+k+1]) {
+				fx = d.vf[off+k+1]
+			} else {
+				fx = d.vf[off+k-1] + 1
+			}
+			fy := fx - k
+			sx, sy := fx, fy
+			for fx < n && fy < m && d.a[a0+fx] == d.b[b0+fy] {
+				fx++
+				fy++
+			}
+			d.vf[off+k] = fx
+			// the backward search has done D-1 rounds, and the same diagonal is delta-k for it
+			if kb := delta - k; odd && kb >= -(D-1) && kb <= D-1 && fx+d.vb[off+kb] >= n {
+				return a0 + sx, b0 + sy, a0 + fx, b0 + fy
+			}
+		}
+
+		for k := -D; k <= D; k += 2 {
+			var bx int
+			if k == -D || (k != D && d.vb[off+k-1] < d.vb[off+k+1]) {
+				bx = d.vb[off+k+1]
+			} else {
+				bx = d.vb[off+k-1] + 1
+			}
+			by := bx - k
+			sx, sy := bx, by
+			for bx < n && by < m && d.a[a1-1-bx] == d.b[b1-1-by] {
+				bx++
+				by++
+			}
+			d.vb[off+k] = bx
+			if kf := delta - k; !odd && kf >= -D && kf <= D && bx+d.vf[off+kf] >= n {
+				return a1 - bx, b1 - by, a1 - sx, b1 - sy
+			}
+		}
+	}
+	// the searches always meet by the time each has covered half of the longest possible script
+	panic("diff: no middle snake")
+}
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type DiffData struct {
+	old  string
+	new  string
+	diff string
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []DiffData{
+		{"a\nb\n", "a\nb\n", ""},
+		{"", "a\n", "--- f\n+++ f\n@@ -0,0 +1 @@\n+a\n"},
+		{"a\n", "", "--- f\n+++ f\n@@ -1 +0,0 @@\n-a\n"},
+		{"a\nb\nc\n", "a\nB\nc\n", "--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"a\nb", "a\nb\n", "--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			"--- f\n+++ f\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n",
+			"1\nx\n3\n4\n5\n6\ny\n",
+			"--- f\n+++ f\n@@ -1,7 +1,7 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+	}
+
+	for i, test := range tests {
+		diff := string(unifiedDiff("f", []byte(test.old), []byte(test.new)))
+		if diff != test.diff {
+			t.Errorf("UnifiedDiff Test %d: Expected diff:\n'%s'\nGot diff:\n'%s'", i, test.diff, diff)
+		}
+	}
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b, for checking that diffLines
+// finds the shortest edit script.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestDiffLines(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, r.Intn(20))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		edits := diffLines(a, b)
+
+		// the edits have to turn a into b, visiting every line of each in order
+		x, y, changes := 0, 0, 0
+		ok := true
+		for _, e := range edits {
+			switch e.op {
+			case ' ':
+				ok = ok && e.a == x && e.b == y && a[x] == b[y]
+				x, y = x+1, y+1
+			case '-':
+				ok = ok && e.a == x
+				x, changes = x+1, changes+1
+			case '+':
+				ok = ok && e.b == y
+				y, changes = y+1, changes+1
+			}
+		}
+		if !ok || x != len(a) || y != len(b) {
+			t.Errorf("DiffLines Test %d: Edits %v don't turn %q into %q", i, edits, a, b)
+			continue
+		}
+		if shortest := len(a) + len(b) - 2*lcsLength(a, b); changes != shortest {
+			t.Errorf("DiffLines Test %d: Expected %d changes from %q to %q, Got %d", i, shortest, a, b, changes)
+		}
+	}
+}
+
+func TestUnifiedDiffLarge(t *testing.T) {
+	// a large generated table that changed completely is the worst case for the diff
+	old := &strings.Builder{}
+	new := &strings.Builder{}
+	for i := 0; i < 6000; i++ {
+		fmt.Fprintf(old, "old row %d\n", i)
+		fmt.Fprintf(new, "new row %d\n", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	d := unifiedDiff("f", []byte(old.String()), []byte(new.String()))
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	if lines := strings.Count(string(d), "\n"); lines != 2+1+12000 {
+		t.Errorf("UnifiedDiffLarge: Expected a single hunk of 12000 changed lines, Got %d lines", lines)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("UnifiedDiffLarge: Expected less than 16 MiB allocated, Got %d MiB", alloc>>20)
+	}
+	if elapsed > 10*time.Second {
+		t.Errorf("UnifiedDiffLarge: Expected the diff to take less than 10s, took %v", elapsed)
+	}
+}
@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Document is content to run gocog over that lives in memory rather than in a file on disk,
+// such as an editor buffer or a file read from an fs.FS.
+type Document struct {
+	// Name identifies the document in errors and logging, it doesn't have to exist on disk
+	Name    string
+	Content []byte
+}
+
+// ReadDocument reads the named file from fsys into a Document.
+func ReadDocument(fsys fs.FS, name string) (Document, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{Name: name, Content: b}, nil
+}
+
+// Process runs the generators in the document and returns the regenerated document.
+// If the document has no gocog code in it, it is returned as is along with NoCogCode.
+func (d Document) Process(ctx context.Context, opts *Options) (Document, error) {
+	b := &bytes.Buffer{}
+	if err := process(ctx, d.Name, bytes.NewReader(d.Content), b, opts); err != nil {
+		return d, err
+	}
+	return Document{Name: d.Name, Content: b.Bytes()}, nil
+}
+
+// Process runs the generators in the content read from r and writes the regenerated content to w.
+// Errors refer to the content as stdin, use a Document to give it a name.
+// If there is no gocog code in the content, nothing is written and NoCogCode is returned.
+// Nothing is logged, so w can be os.Stdout.
+func Process(ctx context.Context, r io.Reader, w io.Writer, opts *Options) error {
+	return process(ctx, "stdin", r, w, opts)
+}
+
+// process runs gocog over the content read from r, writing the generator files to a temporary directory
+// since there is no source file to write them next to. Nothing is logged, since w may well be stdout,
+// and errors are returned instead.
+func process(ctx context.Context, name string, r io.Reader, w io.Writer, opts *Options) error {
+	dir, err := os.MkdirTemp("", "gocog")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	p := New(name, opts)
+	p.SetOutput(io.Discard)
+	p.genDir = dir
+	err = p.gen(ctx, bufio.NewReader(r), w)
+	if err == io.EOF {
+		// got to the end of the content without any other errors
+		return nil
+	}
+	return err
+}
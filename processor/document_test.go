@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+type ProcessData struct {
+	input  string
+	output string
+	err    error
+}
+
+func TestProcess(t *testing.T) {
+	opts := &Options{Command: "cat", Args: []string{"%s"}, StartMark: "[[[", EndMark: "]]]", Quiet: true}
+
+	tests := []ProcessData{
+		{"a\n[[[gocog\nx\ngocog]]]\nold\n[[[end]]]\nb\n", "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\nb\n", nil},
+		{"a\nb\n", "", NoCogCode},
+	}
+
+	for i, test := range tests {
+		out := &bytes.Buffer{}
+		err := Process(context.Background(), bytes.NewBufferString(test.input), out, opts)
+		if err != test.err {
+			t.Errorf("Process Test %d: Expected error %v, got %v", i, test.err, err)
+		}
+		if output := out.String(); output != test.output {
+			t.Errorf("Process Test %d: Expected output:\n'%s'\nGot output:\n'%s'", i, test.output, output)
+		}
+	}
+}
+
+func TestDocumentProcess(t *testing.T) {
+	opts := &Options{Command: "cat", Args: []string{"%s"}, StartMark: "[[[", EndMark: "]]]", Quiet: true}
+
+	// the document's name is a path in a directory that the generator files must not be written to
+	dir := t.TempDir()
+	fsys := fstest.MapFS{
+		"foo.txt": {Data: []byte("// [[[gocog\n// x\n// gocog]]]\n// [[[end]]]\n")},
+	}
+	doc, err := ReadDocument(fsys, "foo.txt")
+	if err != nil {
+		t.Fatalf("DocumentProcess: Unexpected error reading document: %v", err)
+	}
+	doc.Name = filepath.Join(dir, doc.Name)
+
+	out, err := doc.Process(context.Background(), opts)
+	if err != nil {
+		t.Errorf("DocumentProcess: Unexpected error: %v", err)
+	}
+	expected := "// [[[gocog\n// x\n// gocog]]]\nx\n// [[[end]]]\n"
+	if out.Name != doc.Name || string(out.Content) != expected {
+		t.Errorf("DocumentProcess: Expected document %s:\n'%s'\nGot document %s:\n'%s'", doc.Name, expected, out.Name, out.Content)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Errorf("DocumentProcess: Unexpected file '%s' written beside the document", f.Name())
+	}
+}
+
+func TestProcessStdout(t *testing.T) {
+	// even verbose logging mustn't end up in the document when it's written to stdout
+	opts := &Options{Command: "cat", Args: []string{"%s"}, StartMark: "[[[", EndMark: "]]]", Verbose: true}
+	input := "a\n[[[gocog\nx\ngocog]]]\n[[[end]]]\n"
+	expected := "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\n"
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout := os.Stdout
+	os.Stdout = f
+	err = Process(context.Background(), bytes.NewBufferString(input), os.Stdout, opts)
+	os.Stdout = stdout
+	if err != nil {
+		t.Errorf("ProcessStdout: Unexpected error: %v", err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output := string(b); output != expected {
+		t.Errorf("ProcessStdout: Expected output:\n'%s'\nGot output:\n'%s'", expected, output)
+	}
+}
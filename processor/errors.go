@@ -0,0 +1,24 @@
+package processor
+
+import (
+	"fmt"
+)
+
+// SyntaxError describes a malformed gocog block, with the file and line it was found at.
+// Its message is formatted like a compiler error, so editors and CI tools can jump to the line.
+type SyntaxError struct {
+	File string
+	Line int
+	Msg  string
+	// Err is the underlying error, io.ErrUnexpectedEOF if the file ended in the middle of a block
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Unwrap returns the underlying error, so errors.Is(err, io.ErrUnexpectedEOF) still works.
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the files that list patterns of files to skip when walking a directory, in the .gitignore format
+var ignoreFiles = []string{".gitignore", ".gocogignore"}
+
+// ignoreRule is a single pattern from an ignore file, or from --include or --exclude.
+type ignoreRule struct {
+	// dir is the slash separated path of the directory the rule applies to, relative to the root
+	dir     string
+	pattern string
+	// negate is set for patterns starting with !, which re-include what an earlier pattern ignored
+	negate bool
+	// dirOnly is set for patterns ending in /, which only match directories
+	dirOnly bool
+	// anchored is set for patterns containing a /, which match the path relative to dir rather than any name under it
+	anchored bool
+}
+
+// parseRule parses a pattern from an ignore file in dir. Blank lines and comments give no rule.
+func parseRule(dir, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+	r := ignoreRule{dir: dir}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		// escapes a leading ! or #
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	r.pattern = line
+	return r, line != ""
+}
+
+// match reports whether the rule matches the slash separated path rel, relative to the root.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.dir != "" {
+		if !strings.HasPrefix(rel, r.dir+"/") {
+			return false
+		}
+		rel = rel[len(r.dir)+1:]
+	}
+	if r.anchored {
+		return globMatch(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+	}
+	ok, _ := path.Match(r.pattern, path.Base(rel))
+	return ok
+}
+
+// globMatch matches the segments of a slash separated glob against the segments of a path.
+// A ** segment matches any number of path segments, including none.
+func globMatch(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if globMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// MatchGlob reports whether the slash separated path name matches the glob, in the same way
+// as the globs given with --include and --exclude.
+func MatchGlob(glob, name string) bool {
+	return globList([]string{glob}).ignored(name, false)
+}
+
+// ignoreList is a list of rules, where the last rule to match a path decides whether it's ignored.
+type ignoreList []ignoreRule
+
+// globList parses the globs given with --include or --exclude into a list.
+func globList(globs []string) ignoreList {
+	var l ignoreList
+	for _, g := range globs {
+		if r, ok := parseRule("", g); ok {
+			l = append(l, r)
+		}
+	}
+	return l
+}
+
+// ignored reports whether the slash separated path rel, relative to the root, is ignored.
+func (l ignoreList) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range l {
+		if r.match(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// load adds the rules from the ignore files in the directory at path, whose slash separated
+// path relative to the root is rel. Missing ignore files are skipped.
+func (l ignoreList) load(path, rel string) (ignoreList, error) {
+	if rel == "." {
+		rel = ""
+	}
+	for _, name := range ignoreFiles {
+		b, err := os.ReadFile(filepath.Join(path, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if r, ok := parseRule(rel, line); ok {
+				l = append(l, r)
+			}
+		}
+	}
+	return l, nil
+}
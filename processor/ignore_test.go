@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"testing"
+)
+
+type IgnoreData struct {
+	rules   []string
+	path    string
+	isDir   bool
+	ignored bool
+}
+
+func TestIgnored(t *testing.T) {
+	tests := []IgnoreData{
+		{[]string{"*.log"}, "a.log", false, true},
+		{[]string{"*.log"}, "x/y/a.log", false, true},
+		{[]string{"*.log"}, "a.go", false, false},
+		{[]string{"# *.go", "", "*.log"}, "a.go", false, false},
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{[]string{"build/"}, "build", true, true},
+		{[]string{"build/"}, "build", false, false},
+		{[]string{"/a.go"}, "a.go", false, true},
+		{[]string{"/a.go"}, "x/a.go", false, false},
+		{[]string{"x/a.go"}, "x/a.go", false, true},
+		{[]string{"x/a.go"}, "y/x/a.go", false, false},
+		{[]string{"**/gen/*.go"}, "gen/a.go", false, true},
+		{[]string{"**/gen/*.go"}, "x/y/gen/a.go", false, true},
+		{[]string{"x/**/*.go"}, "x/a.go", false, true},
+		{[]string{"x/**/*.go"}, "x/y/z/a.go", false, true},
+		{[]string{"x/**"}, "x/y/z/a.go", false, true},
+		{[]string{`\#a.go`}, "#a.go", false, true},
+	}
+
+	for i, test := range tests {
+		var l ignoreList
+		for _, line := range test.rules {
+			if r, ok := parseRule("", line); ok {
+				l = append(l, r)
+			}
+		}
+		if ignored := l.ignored(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("Ignored Test %d: Expected ignored to be %v for '%s' with rules %q, Got %v", i, test.ignored, test.path, test.rules, ignored)
+		}
+	}
+}
+
+func TestIgnoredInDir(t *testing.T) {
+	// rules from an ignore file in a subdirectory only apply under that directory
+	r, _ := parseRule("sub", "*.go")
+	l := ignoreList{r}
+	if !l.ignored("sub/a.go", false) || !l.ignored("sub/x/a.go", false) {
+		t.Errorf("IgnoredInDir: Expected files under sub to be ignored")
+	}
+	if l.ignored("a.go", false) || l.ignored("subway/a.go", false) {
+		t.Errorf("IgnoredInDir: Expected files outside sub not to be ignored")
+	}
+}
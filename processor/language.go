@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Language describes how to run generator code written in a language.
+// A block picks a language by naming it after the start mark, e.g. [[[gocog:python
+type Language struct {
+	// Command and Args run the generator file, with any %s replaced by the file's name
+	Command string   `json:"cmd"`
+	Args    []string `json:"args"`
+	// Ext is the extension given to the generator file
+	Ext string `json:"ext"`
+	// Engine runs the generator code in-process if it's set, in place of the command
+	Engine Engine `json:"-"`
+}
+
+// Engine runs the generator code of a block in-process, writing the generated output to w.
+type Engine func(ctx context.Context, src Source, w io.Writer) error
+
+// Source is the generator code of a block, for an Engine to run.
+type Source struct {
+	// Name is the name to give the code in errors. References to Name:LINE or Name:LINE:COLUMN
+	// in the engine's error are mapped back to the matching line of the file.
+	Name string
+	// File is the file the block is in, and Line the line of the block's start mark
+	File string
+	Line int
+	// Code is the generator code, with any prefix stripped from its lines
+	Code    string
+	Defines Defines
+}
+
+var (
+	languagesMu sync.RWMutex
+
+	// the languages blocks can name, by name
+	languages = map[string]Language{
+		"go":     {Command: "go", Args: []string{"run", "%s"}, Ext: ".go"},
+		"python": {Command: "python3", Args: []string{"%s"}, Ext: ".py"},
+		"bash":   {Command: "bash", Args: []string{"%s"}, Ext: ".sh"},
+		"node":   {Command: "node", Args: []string{"%s"}, Ext: ".js"},
+		"ruby":   {Command: "ruby", Args: []string{"%s"}, Ext: ".rb"},
+		"perl":   {Command: "perl", Args: []string{"%s"}, Ext: ".pl"},
+		"tmpl":   {Engine: runTemplate},
+	}
+)
+
+// RegisterLanguage adds a language that blocks can name, replacing any language with the same name.
+func RegisterLanguage(name string, lang Language) {
+	lang.Ext = NormalizeExt(lang.Ext)
+	languagesMu.Lock()
+	defer languagesMu.Unlock()
+	languages[name] = lang
+}
+
+// LookupLanguage returns the language registered with the given name.
+func LookupLanguage(name string) (Language, bool) {
+	languagesMu.RLock()
+	defer languagesMu.RUnlock()
+	lang, ok := languages[name]
+	return lang, ok
+}
+
+// language returns how to run the generator code of the block being processed.
+// That's the language named on the block's start line if there is one, or the command from the options.
+func (p *Processor) language() Language {
+	if p.block.lang != nil {
+		return *p.block.lang
+	}
+	return Language{Command: p.Command, Args: p.Args, Ext: p.Ext}
+}
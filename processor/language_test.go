@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// saveLanguages returns a function that puts the registered languages back the way they are now,
+// so that tests registering languages don't leak them into other tests.
+func saveLanguages() (restore func()) {
+	languagesMu.RLock()
+	saved := make(map[string]Language, len(languages))
+	for name, lang := range languages {
+		saved[name] = lang
+	}
+	languagesMu.RUnlock()
+
+	return func() {
+		languagesMu.Lock()
+		languages = saved
+		languagesMu.Unlock()
+	}
+}
+
+func TestRegisterLanguage(t *testing.T) {
+	defer saveLanguages()()
+	RegisterLanguage("gocog_test_cat", Language{Command: "cat", Args: []string{"%s"}, Ext: "txt"})
+	lang, ok := LookupLanguage("gocog_test_cat")
+	if !ok || lang.Command != "cat" || lang.Ext != ".txt" {
+		t.Errorf("RegisterLanguage: Expected the registered language with extension .txt, got %v, %v", lang, ok)
+	}
+	if _, ok := LookupLanguage("gocog_test_missing"); ok {
+		t.Errorf("RegisterLanguage: Expected no language for an unregistered name")
+	}
+
+	// replacing a preset only lasts until the languages are restored
+	restore := saveLanguages()
+	RegisterLanguage("python", Language{Command: "python2", Args: []string{"%s"}, Ext: ".py"})
+	restore()
+	if lang, _ := LookupLanguage("python"); lang.Command != "python3" {
+		t.Errorf("RegisterLanguage: Expected the python preset to be restored, got %v", lang)
+	}
+}
+
+func TestGenLanguages(t *testing.T) {
+	defer saveLanguages()()
+	RegisterLanguage("gocog_test_sh", Language{Command: "sh", Args: []string{"%s"}, Ext: ".sh"})
+
+	// the file's command is cat, which the block naming a language doesn't use
+	opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", Quiet: true}
+	p := New(filepath.Join(t.TempDir(), "foo"), opts)
+
+	input := "# [[[gocog\n# echo a\n# gocog]]]\n# [[[end]]]\n# [[[gocog:gocog_test_sh\n# echo b\n# gocog]]]\n# [[[end]]]\n"
+	expected := "# [[[gocog\n# echo a\n# gocog]]]\necho a\n# [[[end]]]\n# [[[gocog:gocog_test_sh\n# echo b\n# gocog]]]\nb\n# [[[end]]]\n"
+
+	out := &bytes.Buffer{}
+	if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), out); err != io.EOF {
+		t.Errorf("GenLanguages: Expected error %v, got %v", io.EOF, err)
+	}
+	if output := out.String(); output != expected {
+		t.Errorf("GenLanguages: Expected output:\n'%s'\nGot output:\n'%s'", expected, output)
+	}
+	if cmd := p.blocks[1].Command; len(cmd) == 0 || cmd[0] != "sh" {
+		t.Errorf("GenLanguages: Expected second block to run sh, ran %q", cmd)
+	}
+}
@@ -1,23 +1,139 @@
 package processor
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
 type Options struct {
-	UseEOF    bool     `short:"z" long:"eof" description:"The end marker can be assumed at eof."`
-	Verbose   bool     `short:"v" long:"verbose" description:"enables verbose output"`
-	Quiet     bool     `short:"q" long:"quiet" description:"turns off all output"`
-	Serial    bool     `short:"S" long:"serial" description:"Write to the specified cog files serially"`
-	Command   string   `short:"c" long:"cmd" description:"The command used to run the generator code"`
-	Args      []string `short:"a" long:"args" description:"Comma separated arguments to cmd, %s for the code file"`
-	Ext       string   `short:"e" long:"ext" description:"Extension to append to the generator filename"`
-	StartMark string   `short:"M" long:"startmark" description:"String that starts gocog statements"`
-	EndMark   string   `short:"E" long:"endmark" description:"String that ends gocog statements"`
-	Excise    bool     `short:"x" long:"excise" description:"Excise all the generated output without running the generators."`
-	Version   bool     `short:"V" long:"version" description:"Display the version of gocog"`
-	//	Checksum bool              `short:"c" description:"Checksum the output to protect it against accidental change."`
-	//	Delete   bool              `short:"d" description:"Delete the generator code from the output file."`
-	//	Define   map[string]string `short:"D" description:"Define a global string available to your generator code."`
-	//	Include  string            `short:"I" description:"Add PATH to the list of directories for data files and modules."`
-	//	Output   string            `short:"o" description:"Write the output to OUTNAME."`
-	//	Suffix   string            `short:"s" description:"Suffix all generated output lines with STRING."`
-	//	Unix     bool              `short:"U" description:"Write the output with Unix newlines (only LF line-endings)."`
-	//	WriteCmd string            `short:"w" description:"Use CMD if the output file needs to be made writable. A %s in the CMD will be filled with the filename."`
+	UseEOF      bool          `short:"z" long:"eof" description:"The end marker can be assumed at eof."`
+	Verbose     bool          `short:"v" long:"verbose" description:"enables verbose output"`
+	Quiet       bool          `short:"q" long:"quiet" description:"turns off all output"`
+	Serial      bool          `short:"S" long:"serial" description:"Write to the specified cog files serially, same as --jobs=1"`
+	Jobs        int           `short:"j" long:"jobs" description:"The number of files to process at once" value-name:"N"`
+	Command     string        `short:"c" long:"cmd" description:"The command used to run the generator code"`
+	Args        []string      `short:"a" long:"args" description:"Comma separated arguments to cmd, %s for the code file"`
+	Ext         string        `short:"e" long:"ext" description:"Extension to append to the generator filename"`
+	StartMark   string        `short:"M" long:"startmark" description:"String that starts gocog statements"`
+	EndMark     string        `short:"E" long:"endmark" description:"String that ends gocog statements"`
+	Excise      bool          `short:"x" long:"excise" description:"Excise all the generated output without running the generators."`
+	Version     bool          `short:"V" long:"version" description:"Display the version of gocog"`
+	Checksum    bool          `long:"checksum" description:"Checksum the output to protect it against accidental change."`
+	Force       bool          `short:"f" long:"force" description:"Overwrite generated output even if it was changed since it was checksummed."`
+	Check       bool          `long:"check" description:"Check that the generated output is up to date without writing any files."`
+	Diff        bool          `long:"diff" description:"Print a unified diff of the changes to each file without writing any files."`
+	Delete      bool          `short:"d" long:"delete" description:"Delete the generator code from the output file."`
+	OutFile     string        `short:"o" long:"output" description:"Write the output to OUTNAME instead of overwriting the input file." value-name:"OUTNAME"`
+	Define      Defines       `short:"D" long:"define" description:"Define a global string available to your generator code as an environment variable." value-name:"NAME=VALUE"`
+	Include     []string      `short:"I" long:"include-path" description:"Add PATH to the list of directories for data files and modules." value-name:"PATH"`
+	Suffix      string        `short:"s" long:"suffix" description:"Suffix all generated output lines with STRING." value-name:"STRING"`
+	Unix        bool          `short:"U" long:"unix" description:"Write the output with Unix newlines (only LF line-endings)."`
+	WriteCmd    string        `short:"w" long:"writecmd" description:"Use CMD if the output file needs to be made writable. A %s in the CMD will be filled with the filename." value-name:"CMD"`
+	Timeout     time.Duration `long:"timeout" description:"Kill generators that run for longer than DURATION, e.g. 30s. A block can override this with timeout=DURATION after its start mark." value-name:"DURATION"`
+	Report      string        `long:"report" description:"Write a report of every file and block processed, in the given format" choice:"json"`
+	ReportFile  string        `long:"report-file" description:"Write the report to FILE instead of stdout" value-name:"FILE"`
+	ToStdout    bool          `long:"stdout" description:"Write the output to stdout instead of overwriting the input file. An INFILE of - is read from stdin."`
+	IncludeGlob []string      `long:"include" description:"Only process files matching GLOB when searching a DIR/... for files" value-name:"GLOB"`
+	ExcludeGlob []string      `long:"exclude" description:"Skip files and directories matching GLOB when searching a DIR/... for files" value-name:"GLOB"`
+	Config      string        `long:"config" description:"Read the project config from FILE instead of searching for .gocog.json" value-name:"FILE"`
+	Target      string        `long:"target" description:"Process the files of the target NAME from the project config" value-name:"NAME"`
+	CacheDir    string        `long:"cache-dir" description:"Cache generator output in DIR instead of the user's cache directory" value-name:"DIR"`
+	NoCache     bool          `long:"no-cache" description:"Run every generator instead of reusing the output cached from an earlier run"`
+	PruneCache  time.Duration `long:"prune-cache" description:"Remove cached output that hasn't been used for AGE, e.g. 720h" value-name:"AGE"`
+
+	// Cache reuses generator output while the generator code, command, defines and inputs are unchanged.
+	// The command line always caches unless --no-cache is given, while other callers opt in with it.
+	Cache bool `no-flag:"true"`
+}
+
+// NormalizeExt returns the generator file extension with a leading dot, so that an extension can be given
+// as either go or .go.
+func NormalizeExt(ext string) string {
+	if len(ext) > 0 && ext[:1] != "." {
+		return "." + ext
+	}
+	return ext
+}
+
+// Defines holds the global strings given with -D, by name.
+type Defines map[string]string
+
+// UnmarshalFlag parses a single NAME=VALUE definition from the command line.
+func (d Defines) UnmarshalFlag(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("Expected NAME=VALUE for define, got '%s'", value)
+	}
+	d[parts[0]] = parts[1]
+	return nil
+}
+
+// Environ returns the definitions as NAME=VALUE strings, sorted by name, for use as environment variables.
+func (d Defines) Environ() []string {
+	env := make([]string, 0, len(d))
+	for name, value := range d {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// blockOptions holds the options given on a block's start line.
+type blockOptions struct {
+	timeout time.Duration
+	// inputs are the files the generator reads, which the cache key covers
+	inputs []string
+	// name is the name of the language given right after the mark, and lang the language it names
+	name string
+	lang *Language
+}
+
+// parseBlockOptions parses the language and NAME=VALUE options that follow the mark on a block's start line.
+// Anything else after the mark, such as the end of a comment, is ignored.
+func parseBlockOptions(line, mark string) (blockOptions, error) {
+	opts := blockOptions{}
+	i := strings.Index(line, mark)
+	if i < 0 {
+		return opts, nil
+	}
+	rest := line[i+len(mark):]
+	if strings.HasPrefix(rest, ":") {
+		end := strings.IndexFunc(rest[1:], func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '+'
+		})
+		if end < 0 {
+			end = len(rest) - 1
+		}
+		// a colon is only a language tag if a name follows it directly, so start lines like
+		// "[[[gocog: emit the table" still run the file's command
+		if end > 0 {
+			opts.name = rest[1 : end+1]
+			rest = rest[end+1:]
+			lang, ok := LookupLanguage(opts.name)
+			if !ok {
+				return opts, fmt.Errorf("Unknown language '%s' on gocog start line", opts.name)
+			}
+			opts.lang = &lang
+		}
+	}
+	for _, field := range strings.Fields(rest) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "timeout":
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return opts, fmt.Errorf("Invalid timeout '%s' on gocog start line", parts[1])
+			}
+			opts.timeout = d
+		case "inputs":
+			opts.inputs = strings.Split(parts[1], ",")
+		}
+	}
+	return opts, nil
 }
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type DefineData struct {
+	values []string
+	env    []string
+	fails  bool
+}
+
+func TestDefines(t *testing.T) {
+	tests := []DefineData{
+		{[]string{}, []string{}, false},
+		{[]string{"A=1"}, []string{"A=1"}, false},
+		{[]string{"B=2", "A=x=y", "C="}, []string{"A=x=y", "B=2", "C="}, false},
+		{[]string{"A=1", "A=2"}, []string{"A=2"}, false},
+		{[]string{"A"}, nil, true},
+		{[]string{"=1"}, nil, true},
+	}
+
+	for i, test := range tests {
+		d := Defines{}
+		var err error
+		for _, v := range test.values {
+			if err = d.UnmarshalFlag(v); err != nil {
+				break
+			}
+		}
+
+		if test.fails != (err != nil) {
+			t.Errorf("Defines Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+		if test.fails {
+			continue
+		}
+
+		if env := d.Environ(); fmt.Sprint(env) != fmt.Sprint(test.env) {
+			t.Errorf("Defines Test %d: Expected environment %v, got %v", i, test.env, env)
+		}
+	}
+}
+
+type BlockOptionsData struct {
+	line    string
+	timeout time.Duration
+	lang    string
+	inputs  string
+	fails   bool
+}
+
+func TestParseBlockOptions(t *testing.T) {
+	tests := []BlockOptionsData{
+		{"[[[gocog", 0, "", "", false},
+		{"// [[[gocog\n", 0, "", "", false},
+		{"/* [[[gocog timeout=5s\n", 5 * time.Second, "", "", false},
+		{"<!-- [[[gocog timeout=1m30s -->\n", 90 * time.Second, "", "", false},
+		{"[[[gocog timeout=soon\n", 0, "", "", true},
+		{"# [[[gocog:python\n", 0, "python", "", false},
+		{"# [[[gocog:bash timeout=2s\n", 2 * time.Second, "bash", "", false},
+		{"<!-- [[[gocog:node-->\n", 0, "node", "", false},
+		{"[[[gocog:cobol\n", 0, "cobol", "", true},
+		// a colon without a name straight after it isn't a language tag
+		{"// [[[gocog: emit the table\n", 0, "", "", false},
+		{"// [[[gocog:\n", 0, "", "", false},
+		{"# [[[gocog: timeout=4s\n", 4 * time.Second, "", "", false},
+		{"# [[[gocog inputs=a.txt,data/b.json\n", 0, "", "[a.txt data/b.json]", false},
+		{"# [[[gocog:python inputs=schema.json timeout=3s\n", 3 * time.Second, "python", "[schema.json]", false},
+	}
+
+	for i, test := range tests {
+		opts, err := parseBlockOptions(test.line, "[[[gocog")
+		if test.fails != (err != nil) {
+			t.Errorf("ParseBlockOptions Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+		if opts.timeout != test.timeout {
+			t.Errorf("ParseBlockOptions Test %d: Expected timeout %v, got %v", i, test.timeout, opts.timeout)
+		}
+		if opts.name != test.lang || (!test.fails && (opts.lang == nil) != (test.lang == "")) {
+			t.Errorf("ParseBlockOptions Test %d: Expected language '%s', got '%s'", i, test.lang, opts.name)
+		}
+		if inputs := fmt.Sprint(opts.inputs); test.inputs != "" && inputs != test.inputs {
+			t.Errorf("ParseBlockOptions Test %d: Expected inputs %s, got %s", i, test.inputs, inputs)
+		}
+	}
+}
+
+func TestNormalizeExt(t *testing.T) {
+	tests := [][2]string{
+		{"", ""},
+		{".go", ".go"},
+		{"go", ".go"},
+		{"tar.gz", ".tar.gz"},
+	}
+	for i, test := range tests {
+		if ext := NormalizeExt(test[0]); ext != test[1] {
+			t.Errorf("NormalizeExt Test %d: Expected '%s', Got '%s'", i, test[1], ext)
+		}
+	}
+}
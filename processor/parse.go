@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Tree is a file parsed into its plain text and gocog blocks.
+// Printing the tree with WriteTo or Bytes gives back the exact bytes it was parsed from.
+type Tree struct {
+	Name  string
+	Nodes []Node
+}
+
+// Node is a part of a parsed file, either a *Text or a *Block.
+type Node interface {
+	// lines returns the lines of the file that make up the node, with their line endings
+	lines() []string
+}
+
+// Text is plain text outside of any gocog block.
+type Text struct {
+	// Line is the line the text starts on
+	Line  int
+	Lines []string
+}
+
+// Block is a gocog block, from the line with its start mark to the line with its end mark.
+// All lines keep their line endings, and the generator code keeps its prefix.
+type Block struct {
+	// StartLine is the line of the start mark, and EndLine the line of the end mark,
+	// or the last line of the file if the end of the file closes the block
+	StartLine int
+	EndLine   int
+	// Prefix is the text before the start mark, such as a comment, that is stripped from the generator code
+	Prefix string
+	// Language is the name of the language given after the start mark, if any
+	Language string
+	// Start is the line with the start mark, and CodeEnd the line that ends the generator code
+	Start   string
+	Code    []string
+	CodeEnd string
+	// Output is the generated output from the last time gocog ran
+	Output []string
+	// End is the line with the end mark, it's empty if the end of the file closes the block
+	End string
+}
+
+func (t *Text) lines() []string {
+	return t.Lines
+}
+
+func (b *Block) lines() []string {
+	lines := make([]string, 0, len(b.Code)+len(b.Output)+3)
+	lines = append(lines, b.Start)
+	lines = append(lines, b.Code...)
+	lines = append(lines, b.CodeEnd)
+	lines = append(lines, b.Output...)
+	if b.End != "" {
+		lines = append(lines, b.End)
+	}
+	return lines
+}
+
+// WriteTo writes the file the tree was parsed from back out.
+func (t *Tree) WriteTo(w io.Writer) (n int64, err error) {
+	for _, node := range t.Nodes {
+		for _, line := range node.lines() {
+			c, err := io.WriteString(w, line)
+			n += int64(c)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Bytes returns the contents of the file the tree was parsed from.
+func (t *Tree) Bytes() []byte {
+	b := &bytes.Buffer{}
+	t.WriteTo(b)
+	return b.Bytes()
+}
+
+// Blocks returns the gocog blocks in the tree, in order.
+func (t *Tree) Blocks() []*Block {
+	var blocks []*Block
+	for _, node := range t.Nodes {
+		if b, ok := node.(*Block); ok {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// Parse reads the named file from r and parses it into a tree, using the marks and the
+// UseEOF option from opts. No generators are run.
+// Malformed blocks are reported with a SyntaxError, in the same way as when running gocog.
+func Parse(name string, r io.Reader, opts *Options) (*Tree, error) {
+	return New(name, opts).parse(bufio.NewReader(r))
+}
+
+// parse reads the whole of r into a tree.
+func (p *Processor) parse(r *bufio.Reader) (*Tree, error) {
+	t := &Tree{Name: p.File}
+	mark := p.StartMark + "gocog"
+	codeEnd := "gocog" + p.EndMark
+	endMark := p.StartMark + "end" + p.EndMark
+	line := 0
+	for {
+		lines, found, err := readUntil(r, mark)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		text := lines
+		if found {
+			text = lines[:len(lines)-1]
+		}
+		if text = trimEOF(text); len(text) > 0 {
+			t.Nodes = append(t.Nodes, &Text{Line: line + 1, Lines: text})
+		}
+		line += countLines(lines)
+		if !found {
+			return t, nil
+		}
+		if err == io.EOF {
+			return nil, p.unclosed(line, codeEnd)
+		}
+
+		start := lines[len(lines)-1]
+		opts, err := parseBlockOptions(start, mark)
+		if err != nil {
+			return nil, &SyntaxError{File: p.File, Line: line, Msg: err.Error()}
+		}
+		b := &Block{StartLine: line, Prefix: getPrefix(start, mark), Language: opts.name, Start: start}
+		t.Nodes = append(t.Nodes, b)
+
+		lines, found, err = readUntil(r, codeEnd)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if !found {
+			return nil, p.unclosed(b.StartLine, codeEnd)
+		}
+		line += countLines(lines)
+		b.Code = lines[:len(lines)-1]
+		b.CodeEnd = lines[len(lines)-1]
+
+		lines, found, err = readUntil(r, endMark)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line += countLines(lines)
+		b.EndLine = line
+		if !found {
+			if !p.UseEOF {
+				return nil, p.unclosed(b.StartLine, endMark)
+			}
+			b.Output = trimEOF(lines)
+			return t, nil
+		}
+		b.Output = lines[:len(lines)-1]
+		b.End = lines[len(lines)-1]
+		if err == io.EOF {
+			return t, nil
+		}
+	}
+}
+
+// trimEOF drops the empty line that readUntil returns when it reaches the end of the input.
+func trimEOF(lines []string) []string {
+	return lines[:countLines(lines)]
+}
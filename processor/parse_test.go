@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type RoundTripData struct {
+	input  string
+	nodes  int
+	blocks int
+	eof    bool
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []RoundTripData{
+		{"", 0, 0, false},
+		{"a\nb", 1, 0, false},
+		{"a\n[[[gocog\nx\ngocog]]]\nold\n[[[end]]]\nb\n", 3, 1, false},
+		{"[[[gocog\nx\ngocog]]]\n[[[end]]]", 1, 1, false},
+		{"a\r\n// [[[gocog\r\n// x\r\n// gocog]]]\r\nold\r\n// [[[end]]] (checksum: abc)\r\n", 2, 1, false},
+		{"[[[gocog\nx\ngocog]]]\n[[[end]]]\n\n[[[gocog\ny\ngocog]]]\n[[[end]]]\n", 3, 2, false},
+		{"a\n[[[gocog\nx\ngocog]]]\nold\nolder", 2, 1, true},
+		{"a\n[[[gocog\nx\ngocog]]]", 2, 1, true},
+	}
+
+	for i, test := range tests {
+		tree, err := Parse("foo", bytes.NewBufferString(test.input), &Options{StartMark: "[[[", EndMark: "]]]", UseEOF: test.eof})
+		if err != nil {
+			t.Errorf("ParseRoundTrip Test %d: Unexpected error: %v", i, err)
+			continue
+		}
+		if len(tree.Nodes) != test.nodes || len(tree.Blocks()) != test.blocks {
+			t.Errorf("ParseRoundTrip Test %d: Expected %d nodes and %d blocks, Got %d nodes and %d blocks", i, test.nodes, test.blocks, len(tree.Nodes), len(tree.Blocks()))
+		}
+		if output := string(tree.Bytes()); output != test.input {
+			t.Errorf("ParseRoundTrip Test %d: Expected output: %q, Got output: %q", i, test.input, output)
+		}
+	}
+}
+
+func TestParseBlock(t *testing.T) {
+	input := "a\n  // [[[gocog timeout=1s\n  // x\n  // gocog]]]\nold\n  // [[[end]]]\nb\n"
+	tree, err := Parse("foo", bytes.NewBufferString(input), &Options{StartMark: "[[[", EndMark: "]]]"})
+	if err != nil {
+		t.Fatalf("ParseBlock: Unexpected error: %v", err)
+	}
+
+	expected := []Node{
+		&Text{Line: 1, Lines: []string{"a\n"}},
+		&Block{
+			StartLine: 2,
+			EndLine:   6,
+			Prefix:    "// ",
+			Start:     "  // [[[gocog timeout=1s\n",
+			Code:      []string{"  // x\n"},
+			CodeEnd:   "  // gocog]]]\n",
+			Output:    []string{"old\n"},
+			End:       "  // [[[end]]]\n",
+		},
+		&Text{Line: 7, Lines: []string{"b\n"}},
+	}
+	if !reflect.DeepEqual(tree.Nodes, expected) {
+		t.Errorf("ParseBlock: Expected nodes:\n%#v\nGot nodes:\n%#v", expected, tree.Nodes)
+	}
+}
+
+type ParseErrorData struct {
+	input string
+	line  int
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []ParseErrorData{
+		{"a\nb\n[[[gocog", 3},
+		{"a\n[[[gocog\nx\ny\n", 2},
+		{"a\n[[[gocog\nx\ngocog]]]\n[[[end]]]\n\n[[[gocog\nx\ngocog]]]\nold\n", 7},
+		{"a\n[[[gocog timeout=never\nx\ngocog]]]\n[[[end]]]\n", 2},
+	}
+
+	for i, test := range tests {
+		_, err := Parse("foo", bytes.NewBufferString(test.input), &Options{StartMark: "[[[", EndMark: "]]]"})
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Line != test.line {
+			t.Errorf("ParseErrors Test %d: Expected a SyntaxError on line %d, got %v", i, test.line, err)
+		}
+		if i < 3 && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("ParseErrors Test %d: Expected an unexpected EOF error, got %v", i, err)
+		}
+	}
+}
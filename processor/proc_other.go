@@ -0,0 +1,16 @@
+//go:build !unix
+
+package processor
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on systems without process groups.
+func setProcessGroup(c *exec.Cmd) {
+}
+
+// killProcess kills the command's process.
+func killProcess(c *exec.Cmd) error {
+	return c.Process.Kill()
+}
@@ -4,20 +4,37 @@ package processor
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/md5"
 	"errors"
 	"fmt"
+	"github.com/kballard/go-shellquote"
 	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
 	// Indicates a file was processed, but no gocog markers were found in it
 	NoCogCode = errors.New("NoCogCode")
 
+	// Indicates a file was checked, and regenerating it would change its contents
+	OutOfDate = errors.New("OutOfDate")
+
 	newline byte = 10
+
+	// stdoutMu is held while writing a file's output or diff to Stdout, which processors
+	// running at once share, so that what they write doesn't interleave
+	stdoutMu sync.Mutex
+
+	// matches the checksum that follows the end mark, e.g. (checksum: d41d8cd98f00b204e9800998ecf8427e)
+	checksumRegexp = regexp.MustCompile(`^\s*\(checksum: ([0-9a-fA-F]*)\)`)
 )
 
 // New creates a new Processor with the given options.
@@ -27,19 +44,54 @@ func New(file string, opt *Options) *Processor {
 	}
 
 	var logger *log.Logger
-	if opt.Quiet {
+	switch {
+	case opt.Quiet:
 		logger = log.New(io.Discard, "", log.LstdFlags)
-	} else {
+	case opt.Diff || opt.ToStdout || file == "-":
+		// keep stdout clean so the diff or output can be piped elsewhere
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	default:
 		logger = log.New(os.Stdout, "", log.LstdFlags)
 	}
-	return &Processor{file, opt, logger}
+	return &Processor{File: file, Options: opt, Logger: logger, Stdin: os.Stdin, Stdout: os.Stdout}
 }
 
 // Processor holds the data for generating code for a specific file.
+// A File of "-" is read from Stdin, and its output written to Stdout.
 type Processor struct {
 	File string
 	*Options
 	*log.Logger
+
+	// Stdin is read in place of a file named "-"
+	Stdin io.Reader
+	// Stdout receives any output that isn't logging, such as diffs
+	Stdout io.Writer
+
+	// genDir is the directory generator files are written to, next to the file if it's empty
+	genDir string
+
+	// line is the number of lines read from the input so far
+	line int
+	// start is the line of the start mark of the block being processed
+	start int
+	// block holds the options from the start line of the block being processed
+	block blockOptions
+	// stale holds the start lines of blocks whose output changed when regenerated
+	stale []int
+	// eol is the line ending used for generated output, matching the input file
+	eol string
+	// changed is true if running changed, or would have changed, the destination file
+	changed bool
+	// blocks describes each block processed so far, and report is the one being processed
+	blocks []*BlockReport
+	report *BlockReport
+}
+
+// Changed reports whether the last Run changed the destination file. In check and diff mode,
+// where no files are written, it reports whether the destination file would have changed.
+func (p *Processor) Changed() bool {
+	return p.changed
 }
 
 // tracef will only log if verbose output is enabled.
@@ -54,10 +106,30 @@ func (p *Processor) tracef(format string, v ...interface{}) {
 // then run any embedded code, using the given options.
 // It cleans up and code files it writes, and only overwrites the
 // original if generation was successful.
-func (p *Processor) Run() error {
+func (p *Processor) Run(ctx context.Context) error {
 	p.tracef("Processing file '%s'", p.File)
+	p.changed = false
+
+	if p.File == "-" {
+		// there's no directory to write the generator files next to
+		dir, err := os.MkdirTemp("", "gocog")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		p.genDir = dir
+	}
+
+	switch {
+	case p.Check:
+		return p.check(ctx)
+	case p.Diff:
+		return p.diff(ctx)
+	case p.ToStdout || p.File == "-":
+		return p.filter(ctx)
+	}
 
-	output, err := p.tryCog()
+	output, err := p.tryCog(ctx)
 	p.tracef("Output file: '%s'", output)
 
 	if err == NoCogCode {
@@ -70,12 +142,39 @@ func (p *Processor) Run() error {
 
 	// this is the success case - got to the end of the file without any other errors
 	if err == io.EOF {
-		if err := os.Remove(p.File); err != nil {
-			p.Printf("Error removing original file '%s': %s", p.File, err)
+		dest := p.dest()
+		same, err := sameContents(output, dest)
+		if err != nil {
+			p.Printf("Error comparing output with '%s': %s", dest, err)
+			if err := os.Remove(output); err != nil {
+				p.Println(err)
+			}
+			return err
+		}
+		p.changed = !same
+		if same {
+			// leave the file alone, so its modification time doesn't change either
+			if err := os.Remove(output); err != nil {
+				p.Println(err)
+			}
+			p.Printf("Successfully processed '%s', output unchanged", p.File)
+			return nil
+		}
+
+		if err := p.prepareDest(ctx, output, dest); err != nil {
+			p.Printf("Error preparing to overwrite '%s': %s", dest, err)
+			if err := os.Remove(output); err != nil {
+				p.Println(err)
+			}
+			return err
+		}
+		// a separate output file doesn't have to exist yet
+		if err := os.Remove(dest); err != nil && (dest == p.File || !os.IsNotExist(err)) {
+			p.Printf("Error removing original file '%s': %s", dest, err)
 			return err
 		}
-		p.tracef("Renaming output file '%s' to original filename '%s'", output, p.File)
-		if err := os.Rename(output, p.File); err != nil {
+		p.tracef("Renaming output file '%s' to destination filename '%s'", output, dest)
+		if err := os.Rename(output, dest); err != nil {
 			p.Printf("Error renaming cog file '%s': %s", output, err)
 			return err
 		}
@@ -92,10 +191,192 @@ func (p *Processor) Run() error {
 	}
 }
 
+// prepareDest makes the destination file writable with the WriteCmd if it needs to be,
+// and gives the output file the destination's mode so it's kept when the output replaces it.
+func (p *Processor) prepareDest(ctx context.Context, output, dest string) error {
+	info, err := os.Stat(dest)
+	switch {
+	case os.IsNotExist(err):
+		// a new output file gets the mode of the input file
+		if info, err = os.Stat(p.File); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case info.Mode().Perm()&0200 == 0 && p.WriteCmd != "":
+		if err := p.makeWritable(ctx, dest); err != nil {
+			return err
+		}
+		if info, err = os.Stat(dest); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(output, info.Mode().Perm())
+}
+
+// makeWritable runs the WriteCmd for the file, with any %s in the command filled with the filename.
+// Like a generator, the command is killed if it runs for longer than the timeout or the context is done.
+func (p *Processor) makeWritable(ctx context.Context, file string) error {
+	words, err := shellquote.Split(p.WriteCmd)
+	if err != nil {
+		return fmt.Errorf("Error parsing write command '%s': %s", p.WriteCmd, err)
+	}
+	if len(words) == 0 {
+		return errors.New("Write command is empty")
+	}
+	for i, word := range words {
+		words[i] = strings.Replace(word, "%s", file, -1)
+	}
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	p.tracef("Making file '%s' writable", file)
+	p.Printf("running %q", words)
+	stderr, err := run(ctx, words[0], words[1:], nil, p.Writer())
+	if stderr != "" {
+		p.Printf("%s", stderr)
+	}
+	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return fmt.Errorf("Write command for file '%s' timed out after %s", file, p.Timeout)
+		case context.Canceled:
+			return fmt.Errorf("Write command for file '%s' was cancelled", file)
+		}
+		return fmt.Errorf("Error making file '%s' writable: %s", file, err)
+	}
+	return nil
+}
+
+// dest returns the name of the file the output is written to.
+func (p *Processor) dest() string {
+	if p.OutFile != "" {
+		return p.OutFile
+	}
+	return p.File
+}
+
+// regenerate runs the generators over the file in memory, returning both the destination file's
+// current contents and what gocog would write in their place. No files are written.
+// A destination file that doesn't exist yet is treated as empty.
+func (p *Processor) regenerate(ctx context.Context) (orig, output []byte, err error) {
+	in, err := p.readFile()
+	if err != nil {
+		p.Printf("Error reading file '%s': %s", p.File, err)
+		return nil, nil, err
+	}
+
+	orig = in
+	if dest := p.dest(); dest != p.File {
+		orig, err = os.ReadFile(dest)
+		if err != nil && !os.IsNotExist(err) {
+			p.Printf("Error reading file '%s': %s", dest, err)
+			return nil, nil, err
+		}
+	}
+
+	b := &bytes.Buffer{}
+	err = p.gen(ctx, bufio.NewReader(bytes.NewReader(in)), b)
+	if err == NoCogCode {
+		p.Printf("No generator code found in file '%s'", p.File)
+		return nil, nil, err
+	}
+	if err != io.EOF {
+		p.Printf("Error processing cog file '%s': %s", p.File, err)
+		return nil, nil, err
+	}
+	p.changed = !bytes.Equal(orig, b.Bytes())
+	return orig, b.Bytes(), nil
+}
+
+// readFile returns the contents of the file, reading them from Stdin if the file is "-".
+func (p *Processor) readFile() ([]byte, error) {
+	if p.File == "-" {
+		return io.ReadAll(p.Stdin)
+	}
+	return os.ReadFile(p.File)
+}
+
+// filter writes the regenerated file to Stdout. Nothing is written if generation fails,
+// and a file without gocog code is written out as is.
+func (p *Processor) filter(ctx context.Context) error {
+	in, err := p.readFile()
+	if err != nil {
+		p.Printf("Error reading file '%s': %s", p.File, err)
+		return err
+	}
+
+	b := &bytes.Buffer{}
+	genErr := p.gen(ctx, bufio.NewReader(bytes.NewReader(in)), b)
+	switch genErr {
+	case NoCogCode:
+		p.Printf("No generator code found in file '%s'", p.File)
+		b.Write(in)
+	case io.EOF:
+		genErr = nil
+	default:
+		p.Printf("Error processing cog file '%s': %s", p.File, genErr)
+		return genErr
+	}
+
+	p.changed = !bytes.Equal(in, b.Bytes())
+	if err := p.writeStdout(b.Bytes()); err != nil {
+		return err
+	}
+	return genErr
+}
+
+// writeStdout writes all of b to Stdout at once, without any other processor writing in between.
+func (p *Processor) writeStdout(b []byte) error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, err := p.Stdout.Write(b)
+	return err
+}
+
+// check compares the regenerated file with the file's current contents.
+// Each block that would change is logged, and OutOfDate is returned if anything would change.
+func (p *Processor) check(ctx context.Context) error {
+	orig, output, err := p.regenerate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(orig, output) {
+		p.tracef("File '%s' is up to date", p.dest())
+		return nil
+	}
+	if len(p.stale) == 0 {
+		// the output file is out of date, even though the blocks in the input are not
+		p.Printf("%s: Generated output is out of date", p.dest())
+	}
+	for _, line := range p.stale {
+		p.Printf("%s:%d: Generated output is out of date", p.File, line)
+	}
+	return OutOfDate
+}
+
+// diff writes a unified diff between the file's current contents and the regenerated file to Stdout.
+func (p *Processor) diff(ctx context.Context) error {
+	orig, output, err := p.regenerate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if d := unifiedDiff(p.dest(), orig, output); d != nil {
+		return p.writeStdout(d)
+	}
+	return nil
+}
+
 // tryCog encapsulates opening the original file, and creating the temporary output file.
 // If output is nil, no output file was created, otherwise output is a valid file on disk
 // that needs to be cleaned up after this function exits.
-func (p *Processor) tryCog() (output string, err error) {
+func (p *Processor) tryCog(ctx context.Context) (output string, err error) {
 	in, err := os.Open(p.File)
 	if err != nil {
 		return "", err
@@ -104,7 +385,7 @@ func (p *Processor) tryCog() (output string, err error) {
 
 	r := bufio.NewReader(in)
 
-	output = p.File + "_cog"
+	output = p.dest() + "_cog"
 	p.tracef("Writing output to %s", output)
 	out, err := createNew(output)
 	if err != nil {
@@ -112,11 +393,18 @@ func (p *Processor) tryCog() (output string, err error) {
 	}
 	defer out.Close()
 
-	return output, p.gen(r, out)
+	return output, p.gen(ctx, r, out)
 }
 
 // gen enacapsulates the process of generating text from an input and writing to an output.
-func (p *Processor) gen(r *bufio.Reader, w io.Writer) error {
+func (p *Processor) gen(ctx context.Context, r *bufio.Reader, w io.Writer) error {
+	p.line = 0
+	p.stale = nil
+	p.blocks = nil
+	p.eol = ""
+	if p.Unix {
+		w = unixWriter{w}
+	}
 	firstRun := true
 	for {
 		prefix, err := p.cogPlainText(r, w, firstRun)
@@ -124,18 +412,29 @@ func (p *Processor) gen(r *bufio.Reader, w io.Writer) error {
 			return err
 		}
 		firstRun = false
+		p.start = p.line
+		p.report = &BlockReport{StartLine: p.start}
+		p.blocks = append(p.blocks, p.report)
 
-		if err := p.cogGeneratorCode(r, w, prefix); err != nil {
+		output, err := p.cogGeneratorCode(ctx, r, w, prefix)
+		if err != nil {
 			return err
 		}
+		p.report.Bytes = len(output)
 
-		if err := p.cogToEnd(r, w); err != nil {
+		err = p.cogToEnd(r, w, output)
+		if err == nil || err == io.EOF {
+			p.report.EndLine = p.line
+			p.report.Changed = len(p.stale) > 0 && p.stale[len(p.stale)-1] == p.start
+		}
+		if err != nil {
 			return err
 		}
 	}
 }
 
 // cogPlainText reads any plaintext up to and including the startMark.
+// Any options after the startMark are parsed into the Processor's block options.
 // If this is the first time we've read the file and we reach the end before
 // finding the start mark, we won't write anything to the output file.
 // Otherwise we'll write this plaintext back out to the output file as-is.
@@ -144,10 +443,18 @@ func (p *Processor) cogPlainText(r *bufio.Reader, w io.Writer, firstRun bool) (p
 	p.tracef("cogging plaintext")
 	mark := p.StartMark + "gocog"
 	lines, found, err := readUntil(r, mark)
+	p.line += countLines(lines)
+	if firstRun {
+		p.eol = lineEnding(lines[0])
+		if p.Unix {
+			p.eol = "\n"
+		}
+	}
+	start := lines[len(lines)-1]
 	if err == io.EOF {
 		if found {
 			// found gocog statement, but nothing after it
-			return "", io.ErrUnexpectedEOF
+			return "", p.unclosed(p.line, "gocog"+p.EndMark)
 		}
 		if firstRun {
 			// default case - no cog code, don't bother to write out anything
@@ -161,7 +468,10 @@ func (p *Processor) cogPlainText(r *bufio.Reader, w io.Writer, firstRun bool) (p
 	}
 
 	// we can just write out the non-cog code to the output file
-	// this also writes out the cog start line (if any)
+	// this also writes out the cog start line (if any), unless we're deleting the generator code
+	if found && p.Delete {
+		lines = lines[:len(lines)-1]
+	}
 	for _, line := range lines {
 		if _, err := w.Write([]byte(line)); err != nil {
 			return "", err
@@ -173,78 +483,205 @@ func (p *Processor) cogPlainText(r *bufio.Reader, w io.Writer, firstRun bool) (p
 		return "", err
 	}
 
-	return getPrefix(lines[len(lines)-1], mark), err
+	p.block, err = parseBlockOptions(start, mark)
+	if err != nil {
+		return "", &SyntaxError{File: p.File, Line: p.line, Msg: err.Error()}
+	}
+	return getPrefix(start, mark), err
 }
 
 // Reads lines from the reader until reaching the gocog endmark
 // Writes out the generator code to a file with the given name
 // any lines that start with whitespace and then prefix will have
 // the prefix removed (this is to support single line comments)
-func (p *Processor) cogGeneratorCode(r *bufio.Reader, w io.Writer, prefix string) error {
+// The generated output is returned rather than written, so that cogToEnd
+// can check the old output before replacing it.
+func (p *Processor) cogGeneratorCode(ctx context.Context, r *bufio.Reader, w io.Writer, prefix string) ([]byte, error) {
 	p.tracef("cogging generator code")
-	lines, _, err := readUntil(r, "gocog"+p.EndMark)
-	if err == io.EOF {
-		return io.ErrUnexpectedEOF
+	start := p.line
+	lines, found, err := readUntil(r, "gocog"+p.EndMark)
+	p.line += countLines(lines)
+	if err == io.EOF && !found {
+		return nil, p.unclosed(start, "gocog"+p.EndMark)
 	}
-	if err != nil {
-		return err
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
 
 	// we have to write this out both to the output file and to the code file that we'll be running
-	for _, line := range lines {
-		if _, err := w.Write([]byte(line)); err != nil {
-			return err
+	if !p.Delete {
+		for _, line := range lines {
+			if _, err := w.Write([]byte(line)); err != nil {
+				return nil, err
+			}
 		}
+		p.tracef("Wrote %v lines to output file", len(lines))
 	}
-	p.tracef("Wrote %v lines to output file", len(lines))
 
 	if !p.Excise && len(lines) > 0 {
-		if err := p.generate(w, lines[:len(lines)-1], prefix); err != nil {
-			return err
-		}
+		return p.generate(ctx, lines[:len(lines)-1], prefix)
 	}
 
-	return nil
+	return nil, nil
 }
 
-// generate writes out the generator code to a file and runs it.
-// If running the code doesn't return any errors, the output is written to the output file.
-// The file with the generator code is always deleted at the end of this function.
-func (p *Processor) generate(w io.Writer, lines []string, prefix string) error {
+// generate runs the generator code, either in-process if the block's language has an engine,
+// or by writing it out to a file and running the language's command.
+// If running the code doesn't return any errors, the generated output is returned.
+func (p *Processor) generate(ctx context.Context, lines []string, prefix string) ([]byte, error) {
 	p.tracef("generating runnable code")
-	name := filepath.Base(p.File)
-	dir := filepath.Dir(p.File)
-	// prefix the name to ensure it starts with alphanumeric, this is required
-	// to be go-runnable.
-	name = "cog_" + name
-	gen := fmt.Sprintf("%s_cog_%s", filepath.Join(dir, name), p.Ext)
-	defer os.Remove(gen)
+	code, shift := stripPrefix(lines, prefix)
+	lang := p.language()
 
-	// write all but the last line to the generator file
-	if err := writeNewFile(gen, lines, prefix); err != nil {
-		return err
+	timeout := p.Timeout
+	if p.block.timeout > 0 {
+		timeout = p.block.timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	b := bytes.Buffer{}
-	if err := p.runFile(gen, &b); err != nil {
-		return err
+	key := ""
+	if p.Cache && !p.NoCache {
+		var err error
+		if key, err = p.cacheKey(lang, code); err != nil {
+			return nil, err
+		}
 	}
-	if _, err := w.Write(b.Bytes()); err != nil {
-		return err
+
+	b := bytes.Buffer{}
+	if cached, ok := p.cacheGet(key); ok {
+		p.tracef("Using cached output for block at line %d", p.start)
+		// report the command the output came from, as if it had been run
+		gen := p.genFile(lang)
+		p.report.Command = p.command(lang, gen)
+		p.report.Cached = true
+		b.Write(cached)
+	} else {
+		run := p.runCommand
+		if lang.Engine != nil {
+			run = p.runEngine
+		}
+		if err := run(ctx, lang, code, shift, &b); err != nil {
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				return nil, fmt.Errorf("%s:%d: Generator timed out after %s", p.File, p.start, timeout)
+			case context.Canceled:
+				return nil, fmt.Errorf("%s:%d: Generator was cancelled", p.File, p.start)
+			}
+			return nil, err
+		}
+		if key != "" {
+			if err := p.cachePut(key, b.Bytes()); err != nil {
+				p.Printf("Error caching generator output: %s", err)
+			}
+		}
 	}
 
 	// make sure we always end with a newline so we keep [[[end]]] on its own line
 	if b.Len() > 0 && b.Bytes()[b.Len()-1] != newline {
-		if _, err := w.Write([]byte{newline}); err != nil {
+		b.WriteByte(newline)
+	}
+	output := convertLineEndings(b.Bytes(), p.eol)
+	if p.Suffix != "" {
+		output = suffixLines(output, p.Suffix)
+	}
+	return output, nil
+}
+
+// runCommand writes the generator code out to a file and runs it with the language's command.
+// The file with the generator code is always deleted at the end of this function.
+func (p *Processor) runCommand(ctx context.Context, lang Language, code []string, shift []int, w io.Writer) error {
+	gen := p.genFile(lang)
+	defer os.Remove(gen)
+
+	if err := writeNewFile(gen, code); err != nil {
+		return err
+	}
+	lm := lineMap{gen: gen, source: p.File, start: p.start, shift: shift}
+
+	env := p.Define.Environ()
+	if len(p.Include) > 0 {
+		// the workspace only holds links to or copies of the include paths, so it's cheap to build one per block
+		ws, err := os.MkdirTemp("", "gocog")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(ws)
+
+		inc, err := includeEnv(p.Include, ws, ".")
+		if err != nil {
 			return err
 		}
+		env = append(env, inc...)
+	}
+	return p.runFile(ctx, lang, lm, env, w)
+}
+
+// genFile returns the name of the file the generator code is written to.
+func (p *Processor) genFile(lang Language) string {
+	dir := filepath.Dir(p.File)
+	if p.genDir != "" {
+		dir = p.genDir
+	}
+	// prefix the name to ensure it starts with alphanumeric, this is required
+	// to be go-runnable.
+	name := "cog_" + filepath.Base(p.File)
+	return fmt.Sprintf("%s_cog_%s", filepath.Join(dir, name), lang.Ext)
+}
+
+// command returns the command line that runs the generator file with the language's command,
+// with any %s filled with the file's name. Engines have no command line, so the language's name is given.
+func (p *Processor) command(lang Language, gen string) []string {
+	if lang.Engine != nil {
+		return []string{p.block.name}
+	}
+	cmd := lang.Command
+	if strings.Contains(cmd, "%s") {
+		cmd = fmt.Sprintf(cmd, gen)
+	}
+	words := []string{cmd}
+	for _, s := range lang.Args {
+		if strings.Contains(s, "%s") {
+			s = fmt.Sprintf(s, gen)
+		}
+		words = append(words, s)
+	}
+	return words
+}
+
+// runEngine runs the generator code in-process with the language's engine.
+// Any references to lines of the code in the engine's error are mapped back to the source file.
+func (p *Processor) runEngine(ctx context.Context, lang Language, code []string, shift []int, w io.Writer) error {
+	src := Source{
+		Name:    "cog_" + filepath.Base(p.File) + "_cog_",
+		File:    p.File,
+		Line:    p.start,
+		Code:    strings.Join(code, ""),
+		Defines: p.Define,
+	}
+	lm := lineMap{gen: src.Name, source: p.File, start: p.start, shift: shift}
+
+	p.report.Command = p.command(lang, "")
+	start := time.Now()
+	err := lang.Engine(ctx, src, w)
+	p.report.Duration = time.Since(start)
+	if err != nil {
+		p.report.ExitCode = -1
+		p.report.Stderr = lm.rewrite(err.Error())
+		return fmt.Errorf("Error generating code from source: %s", p.report.Stderr)
 	}
 	return nil
 }
 
-// runFile executes the given file with the command line specified in the Processor's options.
+// runFile executes the given generator file with the language's command line,
+// adding env to the command's environment.
 // If the process exits without an error, the output is written to the writer.
-func (p *Processor) runFile(f string, w io.Writer) error {
+// Any references to lines of the generator file in its stderr are mapped back to the source file.
+func (p *Processor) runFile(ctx context.Context, lang Language, lm lineMap, env []string, w io.Writer) error {
+	f := lm.gen
 	p.tracef("output file %v", f)
 	if p.Verbose {
 		contents, err := os.ReadFile(f)
@@ -253,45 +690,116 @@ func (p *Processor) runFile(f string, w io.Writer) error {
 		}
 		p.tracef("file contents:\n%s", contents)
 	}
-	cmd := p.Command
-	if strings.Contains(cmd, "%s") {
-		cmd = fmt.Sprintf(cmd, f)
+	p.report.Command = p.command(lang, f)
+	start := time.Now()
+	p.Printf("running %q", p.report.Command)
+	stderr, err := run(ctx, p.report.Command[0], p.report.Command[1:], env, w)
+	p.report.Duration = time.Since(start)
+	if stderr != "" {
+		stderr = lm.rewrite(stderr)
+		p.Printf("%s", stderr)
 	}
-	args := make([]string, len(p.Args))
-	for i, s := range p.Args {
-		if strings.Contains(s, "%s") {
-			args[i] = fmt.Sprintf(s, f)
-		} else {
-			args[i] = s
+	p.report.Stderr = stderr
+	if err != nil {
+		p.report.ExitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			p.report.ExitCode = exitErr.ExitCode()
 		}
-	}
-
-	if err := run(cmd, args, w, p.Logger); err != nil {
 		return fmt.Errorf("Error generating code from source: %s", err)
 	}
 	return nil
 }
 
-// cogToEnd reads the old generateed code, up until the end tag. All but the last line is discarded.
-func (p *Processor) cogToEnd(r *bufio.Reader, w io.Writer) error {
+// cogToEnd reads the old generated code, up until the end tag, and writes out the new output in its place.
+// If the end tag carries a checksum, the old output is checked against it before it is discarded,
+// so that hand edits to generated code aren't silently lost.
+func (p *Processor) cogToEnd(r *bufio.Reader, w io.Writer, output []byte) error {
 	p.tracef("cogging to end")
-	// we'll drop all but the COG_END line, so no need to keep them in memory
-	line, found, err := findLine(r, p.StartMark+"end"+p.EndMark)
+	endMark := p.StartMark + "end" + p.EndMark
+	lines, found, err := readUntil(r, endMark)
+	p.line += countLines(lines)
 	if err == io.EOF && !found {
 		if !p.UseEOF {
-			return io.ErrUnexpectedEOF
+			return p.unclosed(p.start, endMark)
 		}
 		p.tracef("No gocog end statement, treating EOF as end statement.")
+		if strings.Join(lines, "") != string(output) {
+			p.stale = append(p.stale, p.start)
+		}
+		if _, err := w.Write(output); err != nil {
+			return err
+		}
 		return io.EOF
 	}
 	if err != nil && err != io.EOF {
 		return err
 	}
 
-	// if there's no error, found should always be true, so just write out
-	if _, err := w.Write([]byte(line)); err != nil {
+	// found should always be true here, so the last line is the end statement
+	end := lines[len(lines)-1]
+	if err := p.verifyChecksum(lines[:len(lines)-1], end, endMark); err != nil {
+		return err
+	}
+
+	newEnd := p.checksumLine(end, endMark, output)
+	if strings.Join(lines[:len(lines)-1], "") != string(output) || newEnd != end {
+		p.stale = append(p.stale, p.start)
+	}
+	if _, err := w.Write(output); err != nil {
+		return err
+	}
+	if p.Delete {
+		return err
+	}
+	if _, err := w.Write([]byte(newEnd)); err != nil {
 		return err
 	}
 	p.tracef("Wrote 1 line to output file")
 	return err
 }
+
+// unclosed returns the error for a block opened on the given line that the file ends before closing.
+func (p *Processor) unclosed(line int, mark string) error {
+	msg := fmt.Sprintf("%sgocog block opened here is never closed with %s", p.StartMark, mark)
+	if mark == p.StartMark+"end"+p.EndMark {
+		msg += " (use --eof to let the end of the file close it)"
+	}
+	return &SyntaxError{File: p.File, Line: line, Msg: msg, Err: io.ErrUnexpectedEOF}
+}
+
+// verifyChecksum compares the old output against the checksum in the end statement, if there is one.
+// An error is returned if they don't match, unless the Force option is set.
+func (p *Processor) verifyChecksum(old []string, end, endMark string) error {
+	i := strings.Index(end, endMark)
+	m := checksumRegexp.FindStringSubmatch(end[i+len(endMark):])
+	if m == nil {
+		return nil
+	}
+
+	h := md5.New()
+	for _, line := range old {
+		io.WriteString(h, line)
+	}
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); !strings.EqualFold(sum, m[1]) {
+		if p.Force {
+			p.Printf("%s:%d: Generated output was edited, overwriting it anyway", p.File, p.line)
+			return nil
+		}
+		return fmt.Errorf("%s:%d: Generated output was edited since it was last generated (checksum mismatch), use --force to overwrite it", p.File, p.line)
+	}
+	return nil
+}
+
+// checksumLine returns the end statement with any old checksum removed, and a checksum of the given
+// output added if the Checksum option is set.
+func (p *Processor) checksumLine(end, endMark string, output []byte) string {
+	i := strings.Index(end, endMark) + len(endMark)
+	rest := end[i:]
+	if loc := checksumRegexp.FindStringIndex(rest); loc != nil {
+		rest = rest[loc[1]:]
+	}
+	if p.Checksum {
+		return fmt.Sprintf("%s (checksum: %x)%s", end[:i], md5.Sum(output), rest)
+	}
+	return end[:i] + rest
+}
@@ -3,8 +3,17 @@ package processor
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type CPTData struct {
@@ -39,7 +48,7 @@ func TestCogPlainText(t *testing.T) {
 			t.Errorf("CogPlainText Test %d: Expected prefix: '%s', Got prefix: '%s'", i, test.prefix, prefix)
 		}
 
-		if err != test.err {
+		if !errors.Is(err, test.err) {
 			t.Errorf("CogPlainText Test %d: Expected error: '%v', Got error: '%v'", i, test.err, err)
 		}
 
@@ -81,9 +90,9 @@ func TestCogToEnd(t *testing.T) {
 		out := &bytes.Buffer{}
 
 		r := bufio.NewReader(in)
-		err := p.cogToEnd(r, out)
+		err := p.cogToEnd(r, out, nil)
 
-		if err != test.err {
+		if !errors.Is(err, test.err) {
 			t.Errorf("CogToEnd Test %d: Expected error %v, got %v", i, test.err, err)
 		}
 
@@ -95,3 +104,451 @@ func TestCogToEnd(t *testing.T) {
 	}
 
 }
+
+type ChecksumData struct {
+	input    string
+	output   string
+	gen      string
+	checksum bool
+	force    bool
+	fails    bool
+}
+
+func TestCogToEndChecksum(t *testing.T) {
+	tests := []ChecksumData{
+		{"old\n[[[end]]]\n", "new\n[[[end]]] (checksum: 9cd599a3523898e6a12e13ec787da50a)\n", "new\n", true, false, false},
+		{"old\n[[[end]]] (checksum: 814fa5ca98406a903e22b43d9b610105)\n", "new\n[[[end]]] (checksum: 9cd599a3523898e6a12e13ec787da50a)\n", "new\n", true, false, false},
+		{"old\n[[[end]]] (checksum: 814fa5ca98406a903e22b43d9b610105) */\n", "new\n[[[end]]] */\n", "new\n", false, false, false},
+		{"edited\n[[[end]]] (checksum: 814fa5ca98406a903e22b43d9b610105)\n", "", "new\n", true, false, true},
+		{"edited\n[[[end]]] (checksum: 814fa5ca98406a903e22b43d9b610105)\n", "new\n[[[end]]] (checksum: 9cd599a3523898e6a12e13ec787da50a)\n", "new\n", true, true, false},
+		{"[[[end]]] (checksum: d41d8cd98f00b204e9800998ecf8427e)\n", "[[[end]]]\n", "", false, false, false},
+	}
+
+	opts := &Options{
+		StartMark: "[[[",
+		EndMark:   "]]]",
+		Quiet:     true,
+	}
+	p := New("foo", opts)
+
+	for i, test := range tests {
+		opts.Checksum = test.checksum
+		opts.Force = test.force
+
+		in := bytes.NewBufferString(test.input)
+		out := &bytes.Buffer{}
+
+		r := bufio.NewReader(in)
+		err := p.cogToEnd(r, out, []byte(test.gen))
+
+		if test.fails != (err != nil) {
+			t.Errorf("CogToEndChecksum Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+		if test.fails {
+			continue
+		}
+
+		output := out.String()
+		if output != test.output {
+			t.Errorf("CogToEndChecksum Test %d: Expected output:\n'%s'\nGot output:\n'%s'", i, test.output, output)
+		}
+	}
+}
+
+type CheckData struct {
+	input string
+	err   error
+	stale []int
+}
+
+func TestCheck(t *testing.T) {
+	tests := []CheckData{
+		{"a\n", NoCogCode, nil},
+		{"a\n[[[gocog\ngocog]]]\n[[[end]]]\n", nil, nil},
+		{"a\n[[[gocog\ngocog]]]\nold\n[[[end]]]\n[[[gocog\ngocog]]]\n[[[end]]]\n[[[gocog\ngocog]]]\nold\n[[[end]]]\n", OutOfDate, []int{2, 9}},
+	}
+
+	dir := t.TempDir()
+	for i, test := range tests {
+		name := filepath.Join(dir, fmt.Sprintf("check%d", i))
+		if err := os.WriteFile(name, []byte(test.input), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		p := New(name, &Options{StartMark: "[[[", EndMark: "]]]", Excise: true, Check: true, Quiet: true})
+		if err := p.Run(context.Background()); err != test.err {
+			t.Errorf("Check Test %d: Expected error %v, got %v", i, test.err, err)
+		}
+		if fmt.Sprint(p.stale) != fmt.Sprint(test.stale) {
+			t.Errorf("Check Test %d: Expected stale blocks %v, got %v", i, test.stale, p.stale)
+		}
+
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.input {
+			t.Errorf("Check Test %d: File was modified:\n'%s'", i, b)
+		}
+	}
+}
+
+type GenData struct {
+	input  string
+	output string
+	delete bool
+}
+
+func TestGenDelete(t *testing.T) {
+	tests := []GenData{
+		{"a\n// [[[gocog\n// x\n// gocog]]]\nold\n// [[[end]]]\nb\n", "a\n// [[[gocog\n// x\n// gocog]]]\nx\n// [[[end]]]\nb\n", false},
+		{"a\n// [[[gocog\n// x\n// gocog]]]\nold\n// [[[end]]]\nb\n", "a\nx\nb\n", true},
+		{"<!-- [[[gocog\nx\ny\ngocog]]] -->\n[[[end]]]\n[[[gocog\nz\ngocog]]]\n[[[end]]]", "x\ny\nz\n", true},
+	}
+
+	for i, test := range tests {
+		// cat just echoes the generator code, so we don't need a compiler to test with
+		opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", Delete: test.delete, Quiet: true}
+		p := New(filepath.Join(t.TempDir(), "foo"), opts)
+
+		out := &bytes.Buffer{}
+		err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(test.input)), out)
+		if err != io.EOF {
+			t.Errorf("GenDelete Test %d: Expected error %v, got %v", i, io.EOF, err)
+		}
+
+		output := out.String()
+		if output != test.output {
+			t.Errorf("GenDelete Test %d: Expected output:\n'%s'\nGot output:\n'%s'", i, test.output, output)
+		}
+	}
+}
+
+func TestRunOutFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "foo.txt.cog")
+	out := filepath.Join(dir, "foo.txt")
+	input := "a\n[[[gocog\nx\ngocog]]]\n[[[end]]]\nb\n"
+	if err := os.WriteFile(name, []byte(input), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", OutFile: out, Delete: true, Quiet: true}
+	for i := 0; i < 2; i++ {
+		if err := New(name, opts).Run(context.Background()); err != nil {
+			t.Fatalf("RunOutFile run %d: Unexpected error %v", i, err)
+		}
+
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "a\nx\nb\n" {
+			t.Errorf("RunOutFile run %d: Expected output:\n'a\nx\nb\n'\nGot output:\n'%s'", i, b)
+		}
+		b, err = os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != input {
+			t.Errorf("RunOutFile run %d: Input file was modified:\n'%s'", i, b)
+		}
+	}
+}
+
+type FilterData struct {
+	input  string
+	output string
+	err    error
+}
+
+func TestRunFilter(t *testing.T) {
+	tests := []FilterData{
+		{"a\n[[[gocog\nx\ngocog]]]\nold\n[[[end]]]\n", "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\n", nil},
+		// a file without gocog code is passed through as is
+		{"a\nb\n", "a\nb\n", NoCogCode},
+		// nothing is written if processing fails
+		{"a\n[[[gocog\nx\ngocog]]]\n", "", nil},
+	}
+
+	for i, test := range tests {
+		opts := &Options{Command: "cat", Args: []string{"%s"}, StartMark: "[[[", EndMark: "]]]", Quiet: true}
+		p := New("-", opts)
+		p.Stdin = bytes.NewBufferString(test.input)
+		out := &bytes.Buffer{}
+		p.Stdout = out
+
+		err := p.Run(context.Background())
+		if i == 2 {
+			if err == nil {
+				t.Errorf("RunFilter Test %d: Expected an error, got nil", i)
+			}
+		} else if err != test.err {
+			t.Errorf("RunFilter Test %d: Expected error %v, got %v", i, test.err, err)
+		}
+		if output := out.String(); output != test.output {
+			t.Errorf("RunFilter Test %d: Expected output:\n'%s'\nGot output:\n'%s'", i, test.output, output)
+		}
+	}
+
+	// a named file is read, and left untouched
+	name := filepath.Join(t.TempDir(), "foo.txt")
+	input := "[[[gocog\nx\ngocog]]]\n[[[end]]]\n"
+	if err := os.WriteFile(name, []byte(input), 0666); err != nil {
+		t.Fatal(err)
+	}
+	p := New(name, &Options{Command: "cat", Args: []string{"%s"}, StartMark: "[[[", EndMark: "]]]", Quiet: true, ToStdout: true})
+	out := &bytes.Buffer{}
+	p.Stdout = out
+	if err := p.Run(context.Background()); err != nil {
+		t.Errorf("RunFilter: Unexpected error: %v", err)
+	}
+	if expected := "[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\n"; out.String() != expected {
+		t.Errorf("RunFilter: Expected output:\n'%s'\nGot output:\n'%s'", expected, out.String())
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != input {
+		t.Errorf("RunFilter: Input file was modified:\n'%s'", b)
+	}
+}
+
+// slowWriter is shared by processors running at once, and records whether any of their writes overlapped.
+type slowWriter struct {
+	mu         sync.Mutex
+	buf        bytes.Buffer
+	writing    int32
+	overlapped atomic.Bool
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	if atomic.AddInt32(&w.writing, 1) > 1 {
+		w.overlapped.Store(true)
+	}
+	defer atomic.AddInt32(&w.writing, -1)
+	time.Sleep(5 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(b)
+}
+
+func TestRunDiffConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	out := &slowWriter{}
+	var procs []*Processor
+	for i := 0; i < 8; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("f%d.txt", i))
+		if err := os.WriteFile(name, []byte("[[[gocog\nx\ngocog]]]\nold\n[[[end]]]\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		p := New(name, &Options{Command: "cat", Args: []string{"%s"}, StartMark: "[[[", EndMark: "]]]", Quiet: true, Diff: true})
+		p.Stdout = out
+		procs = append(procs, p)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, p := range procs {
+		wg.Add(1)
+		go func(p *Processor) {
+			defer wg.Done()
+			if err := p.Run(context.Background()); err != nil {
+				t.Errorf("RunDiffConcurrent: Unexpected error: %v", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if out.overlapped.Load() {
+		t.Errorf("RunDiffConcurrent: Expected the diffs to be written one at a time")
+	}
+	for _, p := range procs {
+		diff := fmt.Sprintf("--- %s\n+++ %s\n@@ -1,5 +1,5 @@\n [[[gocog\n x\n gocog]]]\n-old\n+x\n [[[end]]]\n", p.File, p.File)
+		if !strings.Contains(out.buf.String(), diff) {
+			t.Errorf("RunDiffConcurrent: Expected the whole diff of '%s' in the output:\n'%s'", p.File, out.buf.String())
+		}
+	}
+}
+
+func TestGenDefines(t *testing.T) {
+	opts := &Options{Command: "sh", Args: []string{"%s"}, Ext: ".sh", StartMark: "[[[", EndMark: "]]]", Quiet: true,
+		Define: Defines{"GOCOG_TEST_NAME": "world"}}
+	p := New(filepath.Join(t.TempDir(), "foo"), opts)
+
+	input := "# [[[gocog\n# echo \"hello $GOCOG_TEST_NAME\"\n# gocog]]]\n# [[[end]]]\n"
+	expected := "# [[[gocog\n# echo \"hello $GOCOG_TEST_NAME\"\n# gocog]]]\nhello world\n# [[[end]]]\n"
+
+	out := &bytes.Buffer{}
+	if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), out); err != io.EOF {
+		t.Errorf("GenDefines: Expected error %v, got %v", io.EOF, err)
+	}
+	if output := out.String(); output != expected {
+		t.Errorf("GenDefines: Expected output:\n'%s'\nGot output:\n'%s'", expected, output)
+	}
+}
+
+func TestGenSuffix(t *testing.T) {
+	opts := &Options{Command: "sh", Args: []string{"%s"}, Ext: ".sh", StartMark: "[[[", EndMark: "]]]", Quiet: true, Suffix: " # GENERATED"}
+	p := New(filepath.Join(t.TempDir(), "foo"), opts)
+
+	// the generator output doesn't end in a newline, so generate has to add one after the suffix
+	input := "# [[[gocog\n# printf 'a\\n\\nb'\n# gocog]]]\n# [[[end]]]\n"
+	expected := "# [[[gocog\n# printf 'a\\n\\nb'\n# gocog]]]\na # GENERATED\n\nb # GENERATED\n# [[[end]]]\n"
+
+	out := &bytes.Buffer{}
+	if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), out); err != io.EOF {
+		t.Errorf("GenSuffix: Expected error %v, got %v", io.EOF, err)
+	}
+	if output := out.String(); output != expected {
+		t.Errorf("GenSuffix: Expected output:\n'%s'\nGot output:\n'%s'", expected, output)
+	}
+}
+
+type LineEndingGenData struct {
+	input  string
+	output string
+	unix   bool
+}
+
+func TestGenLineEndings(t *testing.T) {
+	tests := []LineEndingGenData{
+		{"a\n[[[gocog\nx\ngocog]]]\n[[[end]]]\n", "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\n", false},
+		{"a\r\n[[[gocog\r\nx\r\ngocog]]]\r\n[[[end]]]\r\n", "a\r\n[[[gocog\r\nx\r\ngocog]]]\r\nx\r\n[[[end]]]\r\n", false},
+		{"a\r\n[[[gocog\r\nx\r\ngocog]]]\r\n[[[end]]]\r\n", "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\n", true},
+		{"a\n[[[gocog\r\nx\r\ngocog]]]\r\n[[[end]]]\r\n", "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\n", true},
+	}
+
+	for i, test := range tests {
+		opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", Unix: test.unix, Quiet: true}
+		p := New(filepath.Join(t.TempDir(), "foo"), opts)
+
+		out := &bytes.Buffer{}
+		if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(test.input)), out); err != io.EOF {
+			t.Errorf("GenLineEndings Test %d: Expected error %v, got %v", i, io.EOF, err)
+		}
+		if output := out.String(); output != test.output {
+			t.Errorf("GenLineEndings Test %d: Expected output: %q, Got output: %q", i, test.output, output)
+		}
+	}
+}
+
+type WriteCmdData struct {
+	mode     os.FileMode
+	writeCmd string
+	result   os.FileMode
+}
+
+func TestRunWriteCmd(t *testing.T) {
+	tests := []WriteCmdData{
+		{0640, "", 0640},
+		{0600, "false", 0600},
+		{0444, "chmod u+w %s", 0644},
+		{0444, "", 0444},
+	}
+
+	input := "[[[gocog\nx\ngocog]]]\n[[[end]]]\n"
+	for i, test := range tests {
+		name := filepath.Join(t.TempDir(), "foo")
+		if err := os.WriteFile(name, []byte(input), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(name, test.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", WriteCmd: test.writeCmd, Quiet: true}
+		if err := New(name, opts).Run(context.Background()); err != nil {
+			t.Errorf("RunWriteCmd Test %d: Unexpected error %v", i, err)
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != test.result {
+			t.Errorf("RunWriteCmd Test %d: Expected mode %v, got %v", i, test.result, info.Mode().Perm())
+		}
+	}
+
+	// a write command that hangs is killed at the timeout, like a generator
+	name := filepath.Join(t.TempDir(), "foo")
+	if err := os.WriteFile(name, []byte(input), 0444); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", WriteCmd: "sleep 10",
+		Timeout: 200 * time.Millisecond, Quiet: true}
+	start := time.Now()
+	err := New(name, opts).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("RunWriteCmd: Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunWriteCmd: Expected the write command to be killed at the timeout, took %v", elapsed)
+	}
+}
+
+func TestGenTimeout(t *testing.T) {
+	opts := &Options{Command: "sh", Args: []string{"%s"}, Ext: ".sh", StartMark: "[[[", EndMark: "]]]", Quiet: true, Timeout: time.Minute}
+	p := New(filepath.Join(t.TempDir(), "foo"), opts)
+
+	// the block's own timeout overrides the one in the options
+	input := "a\n# [[[gocog timeout=100ms\n# sleep 10\n# gocog]]]\n# [[[end]]]\n"
+
+	start := time.Now()
+	err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), ":2: Generator timed out after 100ms") {
+		t.Errorf("GenTimeout: Expected timeout error for block on line 2, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GenTimeout: Generator wasn't killed, took %v", elapsed)
+	}
+}
+
+func TestGenErrorLines(t *testing.T) {
+	opts := &Options{Command: "sh", Args: []string{"%s"}, Ext: ".sh", StartMark: "[[[", EndMark: "]]]", Quiet: true}
+	name := filepath.Join(t.TempDir(), "foo")
+	p := New(name, opts)
+
+	// the generator reports an error on its own second line, which is the fourth line of the source
+	input := "a\n# [[[gocog\n# true\n# echo \"$0:2:5: boom\" >&2; exit 1\n# gocog]]]\n# [[[end]]]\n"
+
+	err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), &bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("GenErrorLines: Expected generator error, got nil")
+	}
+	expected := name + ":4:7: boom\n"
+	if p.report.Stderr != expected {
+		t.Errorf("GenErrorLines: Expected stderr: %q, Got stderr: %q", expected, p.report.Stderr)
+	}
+}
+
+type SyntaxErrorData struct {
+	input string
+	line  int
+	mark  string
+}
+
+func TestGenSyntaxErrors(t *testing.T) {
+	tests := []SyntaxErrorData{
+		{"a\nb\n[[[gocog", 3, "gocog]]]"},
+		{"a\n[[[gocog\nx\ny\n", 2, "gocog]]]"},
+		{"a\n[[[gocog\nx\ngocog]]]\n[[[end]]]\n\n[[[gocog\nx\ngocog]]]\nold\n", 7, "[[[end]]]"},
+		{"a\n[[[gocog timeout=never\nx\ngocog]]]\n[[[end]]]\n", 2, ""},
+	}
+
+	opts := &Options{StartMark: "[[[", EndMark: "]]]", Excise: true, Quiet: true}
+	p := New("foo.txt", opts)
+
+	for i, test := range tests {
+		err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(test.input)), &bytes.Buffer{})
+
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("GenSyntaxErrors Test %d: Expected a SyntaxError, got %v", i, err)
+			continue
+		}
+		if syntaxErr.Line != test.line || !strings.HasPrefix(err.Error(), fmt.Sprintf("foo.txt:%d: ", test.line)) {
+			t.Errorf("GenSyntaxErrors Test %d: Expected error on line %d, got '%v'", i, test.line, err)
+		}
+		if test.mark != "" && (!strings.Contains(err.Error(), "never closed with "+test.mark) || !errors.Is(err, io.ErrUnexpectedEOF)) {
+			t.Errorf("GenSyntaxErrors Test %d: Expected an unexpected EOF error about %s, got '%v'", i, test.mark, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"time"
+)
+
+// The status of a processed file, as given in its FileReport.
+const (
+	// the file was processed and its output changed
+	StatusProcessed = "processed"
+	// the file was processed but its output didn't change
+	StatusUnchanged = "unchanged"
+	// the file has no gocog code in it
+	StatusNoCog = "nocog"
+	// the file couldn't be processed, or was out of date in check mode
+	StatusFailed = "failed"
+)
+
+// FileReport describes the result of running a Processor over a file.
+type FileReport struct {
+	File    string         `json:"file"`
+	Output  string         `json:"output,omitempty"`
+	Status  string         `json:"status"`
+	Changed bool           `json:"changed"`
+	Error   string         `json:"error,omitempty"`
+	Blocks  []*BlockReport `json:"blocks"`
+}
+
+// BlockReport describes how the generator code in one gocog block was run.
+// Blocks whose generator wasn't run, e.g. when excising, have no command.
+type BlockReport struct {
+	StartLine int           `json:"start_line"`
+	EndLine   int           `json:"end_line"`
+	Command   []string      `json:"command,omitempty"`
+	ExitCode  int           `json:"exit_code"`
+	Duration  time.Duration `json:"duration_ns"`
+	Bytes     int           `json:"bytes"`
+	Changed   bool          `json:"changed"`
+	Cached    bool          `json:"cached"`
+	Stderr    string        `json:"stderr,omitempty"`
+}
+
+// Report describes the last Run of the Processor, given the error that Run returned.
+func (p *Processor) Report(err error) FileReport {
+	r := FileReport{
+		File:    p.File,
+		Output:  p.OutFile,
+		Changed: p.changed,
+		Blocks:  p.blocks,
+	}
+	if r.Blocks == nil {
+		r.Blocks = []*BlockReport{}
+	}
+
+	switch {
+	case err == nil && p.changed:
+		r.Status = StatusProcessed
+	case err == nil:
+		r.Status = StatusUnchanged
+	case err == NoCogCode:
+		r.Status = StatusNoCog
+	default:
+		r.Status = StatusFailed
+		r.Error = err.Error()
+	}
+	return r
+}
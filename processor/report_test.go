@@ -0,0 +1,51 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "foo")
+	input := "a\n[[[gocog\nx\ngocog]]]\nx\n[[[end]]]\nb\n[[[gocog\ny\ngocog]]]\n[[[end]]]\n"
+	if err := os.WriteFile(name, []byte(input), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Command: "cat", Args: []string{"%s"}, Ext: ".txt", StartMark: "[[[", EndMark: "]]]", Quiet: true}
+	p := New(name, opts)
+	r := p.Report(p.Run(context.Background()))
+
+	if r.Status != StatusProcessed || !r.Changed || r.Error != "" {
+		t.Errorf("Report: Expected a processed, changed file, got status %s, changed %v, error '%s'", r.Status, r.Changed, r.Error)
+	}
+	if len(r.Blocks) != 2 {
+		t.Fatalf("Report: Expected 2 blocks, got %d", len(r.Blocks))
+	}
+
+	expected := []BlockReport{
+		{StartLine: 2, EndLine: 6, Bytes: 2, Changed: false},
+		{StartLine: 8, EndLine: 11, Bytes: 2, Changed: true},
+	}
+	for i, e := range expected {
+		b := r.Blocks[i]
+		if b.StartLine != e.StartLine || b.EndLine != e.EndLine || b.Bytes != e.Bytes || b.Changed != e.Changed {
+			t.Errorf("Report block %d: Expected %+v, got %+v", i, e, *b)
+		}
+		if len(b.Command) != 2 || b.Command[0] != "cat" || b.ExitCode != 0 {
+			t.Errorf("Report block %d: Unexpected command %q with exit code %d", i, b.Command, b.ExitCode)
+		}
+	}
+
+	r = p.Report(p.Run(context.Background()))
+	if r.Status != StatusUnchanged {
+		t.Errorf("Report: Expected an unchanged file on the second run, got status %s", r.Status)
+	}
+
+	r = New(filepath.Join(t.TempDir(), "missing"), opts).Report(NoCogCode)
+	if r.Status != StatusNoCog || r.Blocks == nil {
+		t.Errorf("Report: Expected status %s with an empty list of blocks, got %s with %v", StatusNoCog, r.Status, r.Blocks)
+	}
+}
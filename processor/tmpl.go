@@ -0,0 +1,185 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// templateData is the data a tmpl block is executed with.
+type templateData struct {
+	// File is the file the block is in, and Line the line of the block's start mark
+	File string
+	Line int
+	// Defines holds the strings given with -D
+	Defines Defines
+}
+
+// runTemplate is the engine of the tmpl language. It executes the generator code as a Go text/template,
+// with the functions from templateFuncs, and the file, start line and defines of the block as data.
+// A template can't be interrupted, so it's executed in the background and abandoned if the context is
+// done first, e.g. at the block's timeout. It stops soon after, the next time it writes any output.
+func runTemplate(ctx context.Context, src Source, w io.Writer) error {
+	t, err := template.New(src.Name).Option("missingkey=error").Funcs(templateFuncs(src)).Parse(src.Code)
+	if err != nil {
+		return err
+	}
+
+	b := &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- t.Execute(ctxWriter{ctx, b}, templateData{File: src.File, Line: src.Line, Defines: src.Defines})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b.Bytes())
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ctxWriter fails every write once its context is done, which stops a template that's been abandoned.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c ctxWriter) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(b)
+}
+
+// templateFuncs returns the functions available to tmpl blocks.
+// Relative paths given to the file functions are relative to the directory of the file the block is in.
+// The string functions take the string last, so they can be used at the end of a pipeline.
+//
+//	file PATH            the contents of the file
+//	lines PATH           the lines of the file, without their line endings
+//	json PATH            the JSON in the file, decoded into maps, slices, strings, numbers and bools
+//	csv PATH             the records in the CSV file, each a list of fields
+//	env NAME             the value of the environment variable, empty if it isn't set
+//	def NAME             the string given with -D NAME=VALUE, an error if it wasn't given
+//	upper S, lower S     S in upper or lower case
+//	title S              S with the first letter of each word in upper case
+//	trim S               S without leading and trailing whitespace
+//	trimPrefix P S       S without the prefix P
+//	trimSuffix X S       S without the suffix X
+//	replace OLD NEW S    S with every OLD replaced with NEW
+//	split SEP S          the parts of S separated by SEP
+//	join SEP LIST        the strings in LIST joined with SEP
+//	contains SUB S       whether S contains SUB
+//	hasPrefix P S        whether S starts with P
+//	hasSuffix X S        whether S ends with X
+//	repeat N S           S repeated N times
+//	indent N S           S with every non-blank line indented by N spaces
+//	quote S              S as a double quoted Go string literal
+func templateFuncs(src Source) template.FuncMap {
+	dir := filepath.Dir(src.File)
+	path := func(name string) string {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	return template.FuncMap{
+		"file": func(name string) (string, error) {
+			b, err := os.ReadFile(path(name))
+			return string(b), err
+		},
+		"lines": func(name string) ([]string, error) {
+			b, err := os.ReadFile(path(name))
+			if err != nil {
+				return nil, err
+			}
+			s := strings.ReplaceAll(string(b), "\r\n", "\n")
+			return strings.Split(strings.TrimSuffix(s, "\n"), "\n"), nil
+		},
+		"json": func(name string) (interface{}, error) {
+			b, err := os.ReadFile(path(name))
+			if err != nil {
+				return nil, err
+			}
+			var v interface{}
+			if err := json.Unmarshal(b, &v); err != nil {
+				return nil, fmt.Errorf("Error parsing JSON file '%s': %s", name, err)
+			}
+			return v, nil
+		},
+		"csv": func(name string) ([][]string, error) {
+			f, err := os.Open(path(name))
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			records, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing CSV file '%s': %s", name, err)
+			}
+			return records, nil
+		},
+		"env": os.Getenv,
+		"def": func(name string) (string, error) {
+			value, ok := src.Defines[name]
+			if !ok {
+				return "", fmt.Errorf("No define named '%s', use -D %s=VALUE", name, name)
+			}
+			return value, nil
+		},
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       func(sep string, list []string) string { return strings.Join(list, sep) },
+		"contains":   func(sub, s string) bool { return strings.Contains(s, sub) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"repeat":     func(n int, s string) string { return strings.Repeat(s, n) },
+		"indent":     indent,
+		"quote":      strconv.Quote,
+	}
+}
+
+// title returns s with the first letter of each word in upper case.
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		upper := unicode.IsSpace(prev)
+		prev = r
+		if upper {
+			return unicode.ToUpper(r)
+		}
+		return r
+	}, s)
+}
+
+// indent returns s with every line that isn't blank indented by n spaces.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = pad + line
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TemplateData struct {
+	code   string
+	output string
+	fails  bool
+}
+
+func TestRunTemplate(t *testing.T) {
+	dir := t.TempDir()
+	data := map[string]string{
+		"names.txt":  "ann\r\nbob\n",
+		"data.json":  `{"name": "gocog", "tags": ["a", "b"]}`,
+		"table.csv":  "id,name\n1,ann\n",
+		"broken.csv": "a,\"b\n",
+	}
+	for name, content := range data {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GOCOG_TEST_ENV", "from env")
+
+	tests := []TemplateData{
+		{"plain text\n", "plain text\n", false},
+		{"{{file \"names.txt\"}}", "ann\r\nbob\n", false},
+		{"{{range lines \"names.txt\"}}[{{.}}]{{end}}", "[ann][bob]", false},
+		{"{{$d := json \"data.json\"}}{{$d.name}} {{index $d.tags 1}}", "gocog b", false},
+		{"{{range csv \"table.csv\"}}{{index . 1}};{{end}}", "name;ann;", false},
+		{"{{csv \"broken.csv\"}}", "", true},
+		{"{{file \"missing.txt\"}}", "", true},
+		{"{{env \"GOCOG_TEST_ENV\"}}", "from env", false},
+		{"{{def \"NAME\"}} {{.Defines.NAME}}", "world world", false},
+		{"{{def \"MISSING\"}}", "", true},
+		{"{{.Line}} {{base .File}}", "", true},
+		{"{{.Line}}", "7", false},
+		{"{{upper \"a\"}}{{lower \"B\"}} {{title \"hello big world\"}}", "Ab Hello Big World", false},
+		{"{{\"  x  \" | trim}} {{\"foo.go\" | trimSuffix \".go\" | trimPrefix \"f\"}}", "x oo", false},
+		{"{{\"a-b-c\" | replace \"-\" \"+\"}} {{\"a,b\" | split \",\" | join \";\"}}", "a+b+c a;b", false},
+		{"{{contains \"b\" \"abc\"}} {{hasPrefix \"a\" \"abc\"}} {{hasSuffix \"a\" \"abc\"}}", "true true false", false},
+		{"{{repeat 3 \"ab\"}} {{quote \"a\\\"b\"}}", "ababab \"a\\\"b\"", false},
+		{"{{indent 2 \"a\\n\\nb\\n\"}}", "  a\n\n  b\n", false},
+	}
+
+	for i, test := range tests {
+		src := Source{Name: "test", File: filepath.Join(dir, "foo.txt"), Line: 7, Code: test.code, Defines: Defines{"NAME": "world"}}
+		out := &bytes.Buffer{}
+		err := runTemplate(context.Background(), src, out)
+		if test.fails != (err != nil) {
+			t.Errorf("RunTemplate Test %d: Expected failure: %v, got error %v", i, test.fails, err)
+		}
+		if !test.fails && out.String() != test.output {
+			t.Errorf("RunTemplate Test %d: Expected output: %q, Got output: %q", i, test.output, out.String())
+		}
+	}
+}
+
+func TestGenTemplate(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{Command: "false", StartMark: "[[[", EndMark: "]]]", Quiet: true, Define: Defines{"WHO": "world"}}
+	p := New(filepath.Join(dir, "foo.md"), opts)
+
+	input := "<!-- [[[gocog:tmpl\nhello {{.Defines.WHO}}\ngocog]]] -->\n<!-- [[[end]]] -->\n"
+	expected := "<!-- [[[gocog:tmpl\nhello {{.Defines.WHO}}\ngocog]]] -->\nhello world\n<!-- [[[end]]] -->\n"
+
+	out := &bytes.Buffer{}
+	if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), out); err != io.EOF {
+		t.Errorf("GenTemplate: Expected error %v, got %v", io.EOF, err)
+	}
+	if output := out.String(); output != expected {
+		t.Errorf("GenTemplate: Expected output:\n'%s'\nGot output:\n'%s'", expected, output)
+	}
+
+	// no generator file is written for a template
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("GenTemplate: Expected no files to be written, found %d", len(files))
+	}
+
+	// errors in the template point at the line in the source file
+	input = "a\n// [[[gocog:tmpl\n// ok\n// {{.Missing}}\n// gocog]]]\n// [[[end]]]\n"
+	err = p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "foo.md")+":4:") {
+		t.Errorf("GenTemplate: Expected an error on line 4 of the source file, got %v", err)
+	}
+
+	// a template that runs too long is stopped at the block's timeout, like any other generator
+	input = "[[[gocog:tmpl timeout=200ms\n{{range 1000000}}{{range 1000000}}x{{end}}{{end}}\ngocog]]]\n[[[end]]]\n"
+	start := time.Now()
+	err = p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(input)), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("GenTemplate: Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GenTemplate: Expected the template to be stopped at the timeout, took %v", elapsed)
+	}
+}
@@ -3,49 +3,74 @@ package processor
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
-// run executes the command with the given arguments, writing output to the given writer and errors to the logger.
-func run(cmd string, args []string, stdout io.Writer, errLog *log.Logger) error {
-	errLog.Printf("running %q", append([]string{cmd}, args...))
+// run executes the command with the given arguments, writing output to the given writer.
+// Any environment variables in env are added to the environment of the command.
+// If the context is done before the command exits, the command and any processes it started are killed.
+// Everything the command wrote to stderr is returned, for the caller to log.
+func run(ctx context.Context, cmd string, args []string, env []string, stdout io.Writer) (stderr string, err error) {
 	errOut := bytes.Buffer{}
-	c := exec.Command(cmd, args...)
+	c := exec.CommandContext(ctx, cmd, args...)
+	setProcessGroup(c)
+	c.Cancel = func() error { return killProcess(c) }
+	// don't wait forever on output from any orphaned grandchildren
+	c.WaitDelay = time.Second
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
 	c.Stdout = stdout
 	c.Stderr = &errOut
 
-	err := c.Run()
-	if errOut.Len() > 0 {
-		errLog.Printf("%s", errOut.String())
+	err = c.Run()
+	return errOut.String(), err
+}
+
+// stripPrefix returns the lines with the prefix stripped out of each line where it's the first
+// non-whitespace text, which supports generator code in single line comments.
+// Windows line endings become Unix ones, since not every interpreter accepts them.
+// The number of columns removed from the start of each line is returned as well.
+func stripPrefix(lines []string, prefix string) (code []string, shift []int) {
+	var reg *regexp.Regexp
+	if len(prefix) > 0 {
+		reg = regexp.MustCompile(fmt.Sprintf(`^(\s*)%s`, regexp.QuoteMeta(prefix)))
+	}
+
+	code = make([]string, len(lines))
+	shift = make([]int, len(lines))
+	for i, line := range lines {
+		if reg != nil && reg.MatchString(line) {
+			line = reg.ReplaceAllString(line, `$1`)
+			shift[i] = len(prefix)
+		}
+		if strings.HasSuffix(line, "\r\n") {
+			line = line[:len(line)-2] + "\n"
+		}
+		code[i] = line
 	}
-	return err
+	return code, shift
 }
 
-// writeNewFile creates a new file and writes the lines to the file, stripping out the prefix if it exists.
+// writeNewFile creates a new file and writes the lines to the file.
 // This will return an error if the file already exists, or if there are any errors during creation.
-// the prefix will be removed if it is the first non-whitespace text in any line
-func writeNewFile(name string, lines []string, prefix string) error {
+func writeNewFile(name string, lines []string) error {
 	out, err := createNew(name)
 	if err != nil {
 		return err
 	}
 
-	var reg *regexp.Regexp
-	if len(prefix) > 0 {
-		reg = regexp.MustCompile(fmt.Sprintf(`^(\s*)%s`, regexp.QuoteMeta(prefix)))
-	}
-
 	for _, line := range lines {
-		if reg != nil {
-			line = reg.ReplaceAllString(line, `$1`)
-		}
 		if _, err := out.Write([]byte(line)); err != nil {
 			if err2 := out.Close(); err2 != nil {
 				return fmt.Errorf("Error writing to and closing newfile %s: %s%s", name, err, err2)
@@ -60,6 +85,49 @@ func writeNewFile(name string, lines []string, prefix string) error {
 	return nil
 }
 
+// lineMap maps the lines of a generator file back to the lines of the source file they came from.
+type lineMap struct {
+	gen    string
+	source string
+	// start is the line of the source file just before the first line of generator code
+	start int
+	// shift holds the number of columns stripped from the start of each line of generator code
+	shift []int
+}
+
+// rewrite replaces references to lines of the generator file in text, such as compiler errors
+// and stack traces, with references to the matching lines of the source file.
+// Both file:line[:column] and Python's "file", line N references are rewritten.
+func (m lineMap) rewrite(text string) string {
+	base := regexp.QuoteMeta(filepath.Base(m.gen))
+
+	ref := regexp.MustCompile(`(?:[A-Za-z]:)?[^\s"'():]*` + base + `:(\d+)(?::(\d+))?`)
+	text = ref.ReplaceAllStringFunc(text, func(s string) string {
+		sub := ref.FindStringSubmatch(s)
+		n, _ := strconv.Atoi(sub[1])
+		s = fmt.Sprintf("%s:%d", m.source, m.start+n)
+		if sub[2] != "" {
+			col, _ := strconv.Atoi(sub[2])
+			s += fmt.Sprintf(":%d", col+m.columns(n))
+		}
+		return s
+	})
+
+	py := regexp.MustCompile(`"[^"]*` + base + `", line (\d+)`)
+	return py.ReplaceAllStringFunc(text, func(s string) string {
+		n, _ := strconv.Atoi(py.FindStringSubmatch(s)[1])
+		return fmt.Sprintf("%q, line %d", m.source, m.start+n)
+	})
+}
+
+// columns returns the number of columns stripped from the given line of the generator file.
+func (m lineMap) columns(line int) int {
+	if line < 1 || line > len(m.shift) {
+		return 0
+	}
+	return m.shift[line-1]
+}
+
 // readUntil reads and returns lines from a reader until the marker is found.
 // found is true if the marker was found. Note that found == true and err == io.EOF is possible.
 func readUntil(r *bufio.Reader, marker string) (lines []string, found bool, err error) {
@@ -75,18 +143,76 @@ func readUntil(r *bufio.Reader, marker string) (lines []string, found bool, err
 	return lines, false, err
 }
 
-// findLine reads lines from a reader until the marker is found, then the line with the marker is returned.
-// found is true if the marker was found. Note that found == true and err == io.EOF is possible.
-func findLine(r *bufio.Reader, marker string) (line string, found bool, err error) {
-	for err == nil {
-		line, err = r.ReadString('\n')
-		if err == nil || err == io.EOF {
-			if strings.Contains(line, marker) {
-				return line, true, err
-			}
+// countLines returns the number of lines in the slice, not counting an empty trailing line at EOF.
+func countLines(lines []string) int {
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		return len(lines) - 1
+	}
+	return len(lines)
+}
+
+// suffixLines adds the suffix to the end of every line of output that isn't blank, before the line ending.
+// The output is expected to end with a newline.
+func suffixLines(output []byte, suffix string) []byte {
+	b := bytes.Buffer{}
+	for _, line := range strings.SplitAfter(string(output), "\n") {
+		if strings.TrimSpace(line) == "" {
+			b.WriteString(line)
+			continue
 		}
+		text := strings.TrimRight(line, "\r\n")
+		b.WriteString(text)
+		b.WriteString(suffix)
+		b.WriteString(line[len(text):])
+	}
+	return b.Bytes()
+}
+
+// lineEnding returns the line ending of the line, \r\n for Windows line endings and \n otherwise.
+func lineEnding(line string) string {
+	if strings.HasSuffix(line, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// convertLineEndings returns the output with every line ending replaced with eol.
+func convertLineEndings(output []byte, eol string) []byte {
+	output = bytes.ReplaceAll(output, []byte("\r\n"), []byte("\n"))
+	if eol == "\r\n" {
+		output = bytes.ReplaceAll(output, []byte("\n"), []byte("\r\n"))
+	}
+	return output
+}
+
+// unixWriter converts Windows line endings to Unix ones in everything written to it.
+// Each write is expected to hold whole lines, so a line ending is never split between writes.
+type unixWriter struct {
+	io.Writer
+}
+
+func (u unixWriter) Write(b []byte) (int, error) {
+	if _, err := u.Writer.Write(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// sameContents reports whether the two files have the same contents.
+// A missing second file is treated as different from any first file.
+func sameContents(a, b string) (bool, error) {
+	contentsA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	contentsB, err := os.ReadFile(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
-	return "", false, err
+	return bytes.Equal(contentsA, contentsB), nil
 }
 
 // createNew creates a new file with the given name, returning an error if the file already exists.
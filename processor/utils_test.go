@@ -48,46 +48,6 @@ func TestReadUntil(t *testing.T) {
 
 }
 
-type FindLineData struct {
-	s     string
-	line  string
-	found bool
-	err   error
-}
-
-func TestFindLine(t *testing.T) {
-
-	tests := []FindLineData{
-		{"a\nb\n", "", false, io.EOF},
-		{"a\nb\nEND", "END", true, io.EOF},
-		{"a\nb\nEND\n", "END\n", true, nil},
-		{"a\nb\nc\nEND\nz", "END\n", true, nil},
-	}
-
-	marker := "END"
-	for i, test := range tests {
-		_ = i
-
-		r := bufio.NewReader(bytes.NewBufferString(test.s))
-
-		line, found, err := findLine(r, marker)
-		if line != test.line {
-			t.Errorf("ReadLine Test %d: Incorrect line returned. Expected: '%s', Got: '%s'", i, test.line, line)
-		}
-		if found != test.found {
-			if test.found {
-				t.Errorf("ReadLine Test %d: Failed to find existing marker.", i)
-			} else {
-				t.Errorf("ReadLine Test %d: Incorrectly found non-existent marker.", i)
-			}
-		}
-		if err != test.err {
-			t.Errorf("ReadLine Test %d: Unexpected error returned. Expected: %v, Got: %v", i, test.err, err)
-		}
-	}
-
-}
-
 type PrefixData struct {
 	input  string
 	prefix string
@@ -111,3 +71,72 @@ func TestGetPrefix(t *testing.T) {
 		}
 	}
 }
+
+type SuffixData struct {
+	input  string
+	output string
+}
+
+func TestSuffixLines(t *testing.T) {
+	tests := []SuffixData{
+		{"", ""},
+		{"a\n", "a // GENERATED\n"},
+		{"a\nb\n", "a // GENERATED\nb // GENERATED\n"},
+		{"a\n\n  \nb\n", "a // GENERATED\n\n  \nb // GENERATED\n"},
+		{"a\r\nb\r\n", "a // GENERATED\r\nb // GENERATED\r\n"},
+	}
+
+	for i, test := range tests {
+		output := string(suffixLines([]byte(test.input), " // GENERATED"))
+		if output != test.output {
+			t.Errorf("SuffixLines Test %d: Expected output: '%s', Got output: '%s'", i, test.output, output)
+		}
+	}
+}
+
+type LineEndingData struct {
+	input  string
+	eol    string
+	output string
+}
+
+func TestConvertLineEndings(t *testing.T) {
+	tests := []LineEndingData{
+		{"a\nb\n", "\n", "a\nb\n"},
+		{"a\r\nb\n", "\n", "a\nb\n"},
+		{"a\nb\n", "\r\n", "a\r\nb\r\n"},
+		{"a\r\nb\n", "\r\n", "a\r\nb\r\n"},
+	}
+
+	for i, test := range tests {
+		output := string(convertLineEndings([]byte(test.input), test.eol))
+		if output != test.output {
+			t.Errorf("ConvertLineEndings Test %d: Expected output: %q, Got output: %q", i, test.output, output)
+		}
+	}
+}
+
+type LineMapData struct {
+	input  string
+	output string
+}
+
+func TestLineMapRewrite(t *testing.T) {
+	lm := lineMap{gen: "/tmp/x/cog_foo.go_cog_.go", source: "foo.go", start: 10, shift: []int{3, 3, 0}}
+
+	tests := []LineMapData{
+		{"", ""},
+		{"/tmp/x/cog_foo.go_cog_.go:2:5: undefined: x\n", "foo.go:12:8: undefined: x\n"},
+		{"./cog_foo.go_cog_.go:3:1: syntax error\n", "foo.go:13:1: syntax error\n"},
+		{"\t/tmp/x/cog_foo.go_cog_.go:1 +0x1d\n", "\tfoo.go:11 +0x1d\n"},
+		{"  File \"/tmp/x/cog_foo.go_cog_.go\", line 2, in <module>\n", "  File \"foo.go\", line 12, in <module>\n"},
+		{"other.go:2:5: unrelated\n", "other.go:2:5: unrelated\n"},
+	}
+
+	for i, test := range tests {
+		output := lm.rewrite(test.input)
+		if output != test.output {
+			t.Errorf("LineMapRewrite Test %d: Expected output: %q, Got output: %q", i, test.output, output)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Walk returns the files under the directory root that hold gocog code, in lexical order.
+// Files and directories ignored by a .gitignore or .gocogignore file are skipped, along with
+// the .git directory. If the options have include globs, only files that match one of them
+// are returned, and anything matching an exclude glob is skipped.
+// Files without the start mark are returned separately as skipped. If optionsFor isn't nil,
+// it returns the options for each file, whose start mark is searched for in place of the one in opts,
+// so that files with their own marks are found.
+func Walk(root string, opts *Options, optionsFor func(path string) (*Options, error)) (files, skipped []string, err error) {
+	include := globList(opts.IncludeGlob)
+	exclude := globList(opts.ExcludeGlob)
+
+	var ignore ignoreList
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				ignore, err = ignore.load(path, rel)
+				return err
+			}
+			if d.Name() == ".git" || ignore.ignored(rel, true) || exclude.ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			ignore, err = ignore.load(path, rel)
+			return err
+		}
+
+		if !d.Type().IsRegular() || ignore.ignored(rel, false) || exclude.ignored(rel, false) {
+			return nil
+		}
+		if len(include) > 0 && !include.ignored(rel, false) {
+			return nil
+		}
+		o := opts
+		if optionsFor != nil {
+			if o, err = optionsFor(path); err != nil {
+				return err
+			}
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(b, []byte(o.StartMark+"gocog")) {
+			files = append(files, path)
+		} else {
+			skipped = append(skipped, path)
+		}
+		return nil
+	})
+	return files, skipped, err
+}
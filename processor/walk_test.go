@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type WalkData struct {
+	include []string
+	exclude []string
+	files   []string
+}
+
+func TestWalk(t *testing.T) {
+	root := t.TempDir()
+	cog := "// [[[gocog\n// gocog]]]\n// [[[end]]]\n"
+	files := map[string]string{
+		".gitignore":        "*.log\nbuild/\n",
+		"a.go":              cog,
+		"b.go":              "no gocog code here\n",
+		"c.txt":             cog,
+		"debug.log":         cog,
+		"build/d.go":        cog,
+		"sub/.gocogignore":  "skip.go\n",
+		"sub/e.go":          cog,
+		"sub/skip.go":       cog,
+		"sub/deeper/f.go":   cog,
+		"vendor/g.go":       cog,
+		".git/h.go":         cog,
+		"other/skip.go":     cog,
+		"other/.gitignore":  "!*.log\n",
+		"other/traced.log":  cog,
+		"other/sub/more.go": cog,
+		"docs/h.md":         "<!-- {{{gocog\n-->\n<!-- {{{end}}} -->\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []WalkData{
+		{nil, nil, []string{"a.go", "c.txt", "other/skip.go", "other/sub/more.go", "other/traced.log", "sub/deeper/f.go", "sub/e.go", "vendor/g.go"}},
+		{[]string{"*.go"}, []string{"vendor"}, []string{"a.go", "other/skip.go", "other/sub/more.go", "sub/deeper/f.go", "sub/e.go"}},
+		{[]string{"sub/**/*.go"}, nil, []string{"sub/deeper/f.go", "sub/e.go"}},
+		{nil, []string{"sub/", "other/**/*.go"}, []string{"a.go", "c.txt", "other/traced.log", "vendor/g.go"}},
+	}
+
+	for i, test := range tests {
+		found, _, err := Walk(root, &Options{StartMark: "[[[", IncludeGlob: test.include, ExcludeGlob: test.exclude}, nil)
+		if err != nil {
+			t.Errorf("Walk Test %d: Unexpected error: %v", i, err)
+			continue
+		}
+		expected := make([]string, len(test.files))
+		for j, f := range test.files {
+			expected[j] = filepath.Join(root, filepath.FromSlash(f))
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("Walk Test %d: Expected files %q, Got files %q", i, expected, found)
+		}
+	}
+
+	// markdown files have their own marks, which the files are searched for instead
+	opts := &Options{StartMark: "[[[", IncludeGlob: []string{"*.md", "b.go"}}
+	optionsFor := func(path string) (*Options, error) {
+		if filepath.Ext(path) == ".md" {
+			return &Options{StartMark: "{{{"}, nil
+		}
+		return opts, nil
+	}
+	found, skipped, err := Walk(root, opts, optionsFor)
+	expected := []string{filepath.Join(root, "docs", "h.md")}
+	if err != nil || !reflect.DeepEqual(found, expected) {
+		t.Errorf("Walk: Expected files %q with per-file options, Got files %q, %v", expected, found, err)
+	}
+	if expected := []string{filepath.Join(root, "b.go")}; !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("Walk: Expected skipped files %q, Got %q", expected, skipped)
+	}
+}
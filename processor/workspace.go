@@ -0,0 +1,206 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the environment variables that hold the module search path of the generator languages we know about
+var searchPathVars = []string{"PYTHONPATH", "NODE_PATH", "RUBYLIB", "PERL5LIB"}
+
+// includeEnv returns environment variables that add the include paths to the search path of the generators.
+// Go has no search path, so Go generators import an include path by its directory name in one of two ways,
+// depending on whether wd, the working directory the generator is run in, is in a module. In a module, a copy
+// of its go.mod that requires each include path as a module of that name is written to dir, and given
+// to the go command with -modfile, so the generator can still import packages from the module and its
+// dependencies. Outside of a module, a GOPATH workspace is built in dir with each include path linked
+// in under src, and the generator is run in GOPATH mode.
+// All include paths are also listed in GOCOG_INCLUDE for generators in any other language.
+func includeEnv(paths []string, dir, wd string) ([]string, error) {
+	abs := make([]string, len(paths))
+	names := map[string]bool{}
+	for i, path := range paths {
+		a, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if names[filepath.Base(a)] {
+			return nil, fmt.Errorf("Include path '%s' has the same name as another include path", path)
+		}
+		names[filepath.Base(a)] = true
+		abs[i] = a
+	}
+
+	gomod, err := findGoMod(wd)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	if gomod != "" {
+		env, err = moduleEnv(gomod, abs, dir)
+	} else {
+		env, err = gopathEnv(abs, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := strings.Join(abs, string(os.PathListSeparator))
+	env = append(env, "GOCOG_INCLUDE="+list)
+	for _, name := range searchPathVars {
+		if old := os.Getenv(name); old != "" {
+			env = append(env, name+"="+list+string(os.PathListSeparator)+old)
+		} else {
+			env = append(env, name+"="+list)
+		}
+	}
+	return env, nil
+}
+
+// findGoMod returns the go.mod file of the module that dir is in, or an empty string if it isn't in one.
+func findGoMod(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		name := filepath.Join(dir, "go.mod")
+		if info, err := os.Stat(name); err == nil && !info.IsDir() {
+			return name, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// moduleEnv writes a copy of the go.mod file to dir that requires each include path as a module named
+// after its directory, replaced with a copy of the include path in dir, and returns the environment
+// that has the go command use it in place of the module's own go.mod.
+func moduleEnv(gomod string, paths []string, dir string) ([]string, error) {
+	b, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	mod := bytes.NewBuffer(b)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		// a replaced directory has to hold a go.mod, so the include path can't just be linked in
+		target := filepath.Join(dir, "mod", name)
+		if err := copyDir(path, target); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(target, "go.mod"), []byte("module "+name+"\n"), 0666); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(mod, "\nrequire %s v0.0.0\n\nreplace %s => %q\n", name, name, target)
+	}
+
+	modfile := filepath.Join(dir, "go.mod")
+	if err := os.WriteFile(modfile, mod.Bytes(), 0666); err != nil {
+		return nil, err
+	}
+	// -modfile reads the checksums from the go.sum next to the alternate go.mod
+	sum := strings.TrimSuffix(gomod, ".mod") + ".sum"
+	if _, err := os.Stat(sum); err == nil {
+		if err := copyFile(sum, filepath.Join(dir, "go.sum")); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := "-modfile=" + modfile
+	if old := os.Getenv("GOFLAGS"); old != "" {
+		flags += " " + old
+	}
+	// -modfile can't be used in workspace mode
+	return []string{"GOFLAGS=" + flags, "GOWORK=off"}, nil
+}
+
+// gopathEnv builds a GOPATH workspace in dir with each include path linked in under src,
+// and returns the environment that runs the go command in GOPATH mode in it.
+func gopathEnv(paths []string, dir string) ([]string, error) {
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0777); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if err := linkDir(path, filepath.Join(src, filepath.Base(path))); err != nil {
+			return nil, err
+		}
+	}
+	return []string{"GOPATH=" + dir, "GO111MODULE=off"}, nil
+}
+
+// linkDir makes the directory src available at dst, with a symlink if possible or by copying it otherwise.
+func linkDir(src, dst string) error {
+	if err := checkDir(src); err != nil {
+		return err
+	}
+	if err := os.Symlink(src, dst); err == nil {
+		return nil
+	}
+	return copyDir(src, dst)
+}
+
+// copyDir copies the directory src and everything in it to dst, except for a go.mod or go.sum
+// at the top, which would make the copy a different module.
+func copyDir(src, dst string) error {
+	if err := checkDir(src); err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0777)
+		}
+		if rel == "go.mod" || rel == "go.sum" {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// checkDir returns an error if the include path isn't a directory.
+func checkDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Include path '%s' is not a directory", path)
+	}
+	return nil
+}
+
+// copyFile copies the contents of the file src to a new file dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createNew(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncludeEnv(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"a/helpers", "b/helpers", "c/util"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a/helpers/h.go"), []byte("package helpers\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := filepath.Join(dir, "ws")
+	if err := os.Mkdir(ws, 0777); err != nil {
+		t.Fatal(err)
+	}
+	env, err := includeEnv([]string{filepath.Join(dir, "a/helpers"), filepath.Join(dir, "c/util")}, ws, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := filepath.Join(dir, "a/helpers") + string(os.PathListSeparator) + filepath.Join(dir, "c/util")
+	for _, expected := range []string{"GOCOG_INCLUDE=" + list, "GOPATH=" + ws, "GO111MODULE=off"} {
+		found := false
+		for _, e := range env {
+			found = found || e == expected
+		}
+		if !found {
+			t.Errorf("IncludeEnv: Expected %s in environment %v", expected, env)
+		}
+	}
+	for _, e := range env {
+		if strings.HasPrefix(e, "PYTHONPATH=") && !strings.HasPrefix(e, "PYTHONPATH="+list) {
+			t.Errorf("IncludeEnv: Include paths not at the start of %s", e)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(ws, "src/helpers/h.go")); err != nil {
+		t.Errorf("IncludeEnv: Include path not available in workspace: %v", err)
+	}
+
+	ws2 := filepath.Join(dir, "ws2")
+	if err := os.Mkdir(ws2, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := includeEnv([]string{filepath.Join(dir, "a/helpers"), filepath.Join(dir, "b/helpers")}, ws2, dir); err == nil {
+		t.Errorf("IncludeEnv: Expected an error for include paths with the same name")
+	}
+
+	ws3 := filepath.Join(dir, "ws3")
+	if err := os.Mkdir(ws3, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := includeEnv([]string{filepath.Join(dir, "a/helpers/h.go")}, ws3, dir); err == nil {
+		t.Errorf("IncludeEnv: Expected an error for an include path that isn't a directory")
+	}
+}
+
+func TestIncludeEnvModule(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":                 "module example.com/gen\n\ngo 1.21\n",
+		"lib/lib.go":             "package lib\n\nconst Name = \"lib\"\n",
+		"tools/helpers/h.go":     "package helpers\n\nfunc Hello() string { return \"hello\" }\n",
+		"tools/helpers/go.mod":   "module example.com/other\n",
+		"tools/helpers/sub/s.go": "package sub\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws := filepath.Join(dir, "ws")
+	if err := os.Mkdir(ws, 0777); err != nil {
+		t.Fatal(err)
+	}
+	env, err := includeEnv([]string{filepath.Join(dir, "tools/helpers")}, ws, filepath.Join(dir, "lib"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range env {
+		if e == "GO111MODULE=off" || strings.HasPrefix(e, "GOPATH=") {
+			t.Errorf("IncludeEnvModule: Expected module mode in a module, got %s", e)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(ws, "mod/helpers/go.mod")); err != nil || string(b) != "module helpers\n" {
+		t.Errorf("IncludeEnvModule: Expected the include path to be copied in as module helpers, got '%s', %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "mod/helpers/sub/s.go")); err != nil {
+		t.Errorf("IncludeEnvModule: Include path not copied into workspace: %v", err)
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	// the generator can import both the include path and packages from its own module
+	opts := &Options{Command: "go", Args: []string{"run", "%s"}, Ext: ".go", StartMark: "[[[", EndMark: "]]]", Quiet: true,
+		Include: []string{filepath.Join(dir, "tools/helpers")}}
+	// the go command finds the module from the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	p := New("gen.txt", opts)
+	code := "[[[gocog\npackage main\nimport (\n\"fmt\"\n\"helpers\"\n\"example.com/gen/lib\"\n)\nfunc main() { fmt.Println(helpers.Hello(), lib.Name) }\ngocog]]]\n[[[end]]]\n"
+	out := &bytes.Buffer{}
+	if err := p.gen(context.Background(), bufio.NewReader(bytes.NewBufferString(code)), out); err != io.EOF {
+		t.Fatalf("IncludeEnvModule: Expected error %v, got %v", io.EOF, err)
+	}
+	if !strings.Contains(out.String(), "\nhello lib\n") {
+		t.Errorf("IncludeEnvModule: Expected the generator's output, got:\n'%s'", out.String())
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "go.mod")); string(b) != files["go.mod"] {
+		t.Errorf("IncludeEnvModule: Expected the module's go.mod to be left alone, got:\n'%s'", b)
+	}
+}